@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/appstream"
@@ -34,6 +35,13 @@ type Config struct {
 	// Domain Join Configuration
 	DirectoryName                       *string `mapstructure:"directory_name" required:"false"`
 	OrganizationalUnitDistinguishedName *string `mapstructure:"organizational_unit_distinguished_name" required:"false"`
+	// If true, remove the computer object the ImageBuilder created in the
+	// directory before the image is captured, or when the build fails.
+	// Requires `directory_name`. Default `false`.
+	CleanupComputerAccount bool `mapstructure:"cleanup_computer_account" required:"false"`
+	// The command run on the ImageBuilder to remove its computer object. The
+	// default deletes it through ADSI as the communicator user.
+	ComputerAccountCleanupCommand string `mapstructure:"computer_account_cleanup_command" required:"false"`
 
 	// VPC Configuration
 	SecurityGroupIds []string `mapstructure:"security_group_ids" required:"false"`
@@ -49,6 +57,40 @@ type Config struct {
 	// during a build test stage. Default `false`.
 	SkipCreateImage bool `mapstructure:"skip_create_image" required:"false"`
 
+	// If true, Packer resolves the source image, network, directory and agent
+	// inputs and prints the plan of what it would create, without launching an
+	// Image Builder. Default `false`.
+	DryRun bool `mapstructure:"dry_run" required:"false"`
+	// Path to write the resolved plan to as JSON when `dry_run` is set.
+	PlanOutput string `mapstructure:"plan_output" required:"false"`
+
+	// If true, install all available Windows updates before capturing the
+	// image, restarting the ImageBuilder as often as needed. Installed KBs are
+	// reported in the manifest. Default `false`.
+	WindowsUpdate bool `mapstructure:"windows_update" required:"false"`
+	// If true, install the latest EC2Launch and restart the ImageBuilder on the
+	// latest AppStream agent before capturing the image. Default `false`.
+	UpdateAgentBeforeCapture bool `mapstructure:"update_agent_before_capture" required:"false"`
+	// If true, launch every registered application once as the Template User
+	// before capturing the image, so that its launch performance is optimized,
+	// and verify that its optimization manifest exists. Applications without a
+	// manifest of their own must be listed in the default one. Default `false`.
+	OptimizeApplications bool `mapstructure:"optimize_applications" required:"false"`
+	// How long to keep each application running while optimizing. Default `1m`.
+	OptimizeApplicationsWait time.Duration `mapstructure:"optimize_applications_wait" required:"false"`
+	// The local account applications are launched as while optimizing.
+	// Default `ImageBuilderTemplateUser`.
+	TemplateUser string `mapstructure:"template_user" required:"false"`
+	// How long to wait for the ImageBuilder to come back after a restart, and
+	// to stop and start again with `update_agent_before_capture`. Default
+	// `15m`.
+	RestartTimeout time.Duration `mapstructure:"restart_timeout" required:"false"`
+
+	// Path to write a JSON manifest describing the build to: source image,
+	// builder, IP, agent version, timings, resulting image ARNs, tags and
+	// applications.
+	ManifestOutput string `mapstructure:"manifest_output" required:"false"`
+
 	// Name of the resulting image
 	Name string `mapstructure:"name" required:"true"`
 	// Name of the AppStream Image Builder
@@ -110,10 +152,34 @@ func (b *Builder) Prepare(raws ...any) ([]string, []string, error) {
 		b.config.AppstreamAgentVersion = "LATEST"
 	}
 
+	if b.config.OptimizeApplicationsWait == 0 {
+		b.config.OptimizeApplicationsWait = time.Minute
+	}
+
+	if b.config.TemplateUser == "" {
+		b.config.TemplateUser = "ImageBuilderTemplateUser"
+	}
+
+	if b.config.RestartTimeout == 0 {
+		b.config.RestartTimeout = 15 * time.Minute
+	}
+
 	if es := b.config.Comm.Prepare(&b.config.ctx); len(es) > 0 {
 		errs = packersdk.MultiErrorAppend(errs, es...)
 	}
 
+	if b.config.OrganizationalUnitDistinguishedName != nil && b.config.DirectoryName == nil {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("organizational_unit_distinguished_name requires directory_name"))
+	}
+
+	if b.config.CleanupComputerAccount && b.config.DirectoryName == nil {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("cleanup_computer_account requires directory_name"))
+	}
+
+	if b.config.PlanOutput != "" && !b.config.DryRun {
+		warns = append(warns, "plan_output is only written when dry_run is true")
+	}
+
 	if errs != nil && len(errs.Errors) != 0 {
 		return nil, warns, errs
 	}
@@ -140,25 +206,54 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 
 	// generatedData := &packerbuilderdata.GeneratedData{State: state}
 
+	connect := &communicator.StepConnect{
+		// StepConnect is provided settings for WinRM and SSH, but
+		// the communicator will ultimately determine which port to use.
+		Config:    &b.config.Comm,
+		Host:      communicator.CommHost(b.config.Comm.Host(), "ip"),
+		SSHConfig: b.config.Comm.SSHConfigFunc(),
+	}
+
 	steps := []multistep.Step{
 		&StepImageBuilderCreate{
 			config: b.config,
 		},
-		&communicator.StepConnect{
-			// StepConnect is provided settings for WinRM and SSH, but
-			// the communicator will ultimately determine which port to use.
-			Config:    &b.config.Comm,
-			Host:      communicator.CommHost(b.config.Comm.Host(), "ip"),
-			SSHConfig: b.config.Comm.SSHConfigFunc(),
-		},
+		connect,
+	}
+
+	if b.config.CleanupComputerAccount {
+		steps = append(steps, &StepComputerAccountCleanup{config: b.config, onFailure: true})
+	}
+
+	steps = append(steps,
 		// &awscommon.StepSetGeneratedData{
 		// 	GeneratedData: generatedData,
 		// },
 		&commonsteps.StepProvision{},
-		&StepImageBuilderSnapshot{b.config},
+	)
+
+	if b.config.WindowsUpdate {
+		steps = append(steps, &StepWindowsUpdate{b.config})
+	}
+	if b.config.UpdateAgentBeforeCapture {
+		steps = append(steps, &StepUpdateAgent{config: b.config, connect: connect})
+	}
+	if b.config.OptimizeApplications {
+		steps = append(steps, &StepOptimizeApplications{b.config})
+	}
+	if b.config.CleanupComputerAccount {
+		steps = append(steps, &StepComputerAccountCleanup{config: b.config})
+	}
+	steps = append(steps, &StepImageBuilderSnapshot{b.config})
+
+	if b.config.DryRun {
+		steps = []multistep.Step{
+			&StepDryRun{b.config},
+		}
 	}
 
 	// Run!
+	start := time.Now()
 	b.runner = commonsteps.NewRunnerWithPauseFn(steps, b.config.PackerConfig, ui, state)
 	b.runner.Run(ctx, state)
 	// If there was an error, return that
@@ -175,9 +270,24 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		return nil, errors.New("build was halted")
 	}
 
+	if b.config.DryRun {
+		// Nothing was created
+		return nil, nil
+	}
+
+	if b.config.ManifestOutput != "" {
+		manifest := buildManifest(&b.config, state, start, time.Now())
+		if err := writeManifest(b.config.ManifestOutput, manifest); err != nil {
+			ui.Error(fmt.Sprintf("Unable to write out manifest to %s: %s", b.config.ManifestOutput, err))
+		}
+	}
+
+	// Skipping image creation leaves no images behind
+	images, _ := state.Get("images").(map[string]string)
+
 	// Build the artifact and return it
 	artifact := &Artifact{
-		Images:         state.Get("images").(map[string]string),
+		Images:         images,
 		BuilderIdValue: BuilderId,
 		StateData:      map[string]any{"generated_data": state.Get("generated_data")},
 		Config:         *cfg,
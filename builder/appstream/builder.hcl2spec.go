@@ -37,6 +37,8 @@ type FlatConfig struct {
 	PollingConfig                       *common.FlatAWSPollingConfig      `mapstructure:"aws_polling" required:"false" cty:"aws_polling" hcl:"aws_polling"`
 	DirectoryName                       *string                           `mapstructure:"directory_name" required:"false" cty:"directory_name" hcl:"directory_name"`
 	OrganizationalUnitDistinguishedName *string                           `mapstructure:"organizational_unit_distinguished_name" required:"false" cty:"organizational_unit_distinguished_name" hcl:"organizational_unit_distinguished_name"`
+	CleanupComputerAccount              *bool                             `mapstructure:"cleanup_computer_account" required:"false" cty:"cleanup_computer_account" hcl:"cleanup_computer_account"`
+	ComputerAccountCleanupCommand       *string                           `mapstructure:"computer_account_cleanup_command" required:"false" cty:"computer_account_cleanup_command" hcl:"computer_account_cleanup_command"`
 	SecurityGroupIds                    []string                          `mapstructure:"security_group_ids" required:"false" cty:"security_group_ids" hcl:"security_group_ids"`
 	SubnetIds                           []string                          `mapstructure:"subnet_ids" required:"false" cty:"subnet_ids" hcl:"subnet_ids"`
 	VolumeSizeInGb                      *int32                            `mapstructure:"volume_size_in_gb" required:"false" cty:"volume_size_in_gb" hcl:"volume_size_in_gb"`
@@ -90,6 +92,15 @@ type FlatConfig struct {
 	WinRMInsecure                       *bool                             `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
 	WinRMUseNTLM                        *bool                             `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
 	SkipCreateImage                     *bool                             `mapstructure:"skip_create_image" required:"false" cty:"skip_create_image" hcl:"skip_create_image"`
+	DryRun                              *bool                             `mapstructure:"dry_run" required:"false" cty:"dry_run" hcl:"dry_run"`
+	PlanOutput                          *string                           `mapstructure:"plan_output" required:"false" cty:"plan_output" hcl:"plan_output"`
+	WindowsUpdate                       *bool                             `mapstructure:"windows_update" required:"false" cty:"windows_update" hcl:"windows_update"`
+	UpdateAgentBeforeCapture            *bool                             `mapstructure:"update_agent_before_capture" required:"false" cty:"update_agent_before_capture" hcl:"update_agent_before_capture"`
+	OptimizeApplications                *bool                             `mapstructure:"optimize_applications" required:"false" cty:"optimize_applications" hcl:"optimize_applications"`
+	OptimizeApplicationsWait            *string                           `mapstructure:"optimize_applications_wait" required:"false" cty:"optimize_applications_wait" hcl:"optimize_applications_wait"`
+	TemplateUser                        *string                           `mapstructure:"template_user" required:"false" cty:"template_user" hcl:"template_user"`
+	RestartTimeout                      *string                           `mapstructure:"restart_timeout" required:"false" cty:"restart_timeout" hcl:"restart_timeout"`
+	ManifestOutput                      *string                           `mapstructure:"manifest_output" required:"false" cty:"manifest_output" hcl:"manifest_output"`
 	Name                                *string                           `mapstructure:"name" required:"true" cty:"name" hcl:"name"`
 	BuilderName                         *string                           `mapstructure:"builder_name" required:"true" cty:"builder_name" hcl:"builder_name"`
 	Description                         *string                           `mapstructure:"description" required:"false" cty:"description" hcl:"description"`
@@ -143,6 +154,8 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"aws_polling":                            &hcldec.BlockSpec{TypeName: "aws_polling", Nested: hcldec.ObjectSpec((*common.FlatAWSPollingConfig)(nil).HCL2Spec())},
 		"directory_name":                         &hcldec.AttrSpec{Name: "directory_name", Type: cty.String, Required: false},
 		"organizational_unit_distinguished_name": &hcldec.AttrSpec{Name: "organizational_unit_distinguished_name", Type: cty.String, Required: false},
+		"cleanup_computer_account":               &hcldec.AttrSpec{Name: "cleanup_computer_account", Type: cty.Bool, Required: false},
+		"computer_account_cleanup_command":       &hcldec.AttrSpec{Name: "computer_account_cleanup_command", Type: cty.String, Required: false},
 		"security_group_ids":                     &hcldec.AttrSpec{Name: "security_group_ids", Type: cty.List(cty.String), Required: false},
 		"subnet_ids":                             &hcldec.AttrSpec{Name: "subnet_ids", Type: cty.List(cty.String), Required: false},
 		"volume_size_in_gb":                      &hcldec.AttrSpec{Name: "volume_size_in_gb", Type: cty.Number, Required: false},
@@ -196,6 +209,15 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"winrm_insecure":                         &hcldec.AttrSpec{Name: "winrm_insecure", Type: cty.Bool, Required: false},
 		"winrm_use_ntlm":                         &hcldec.AttrSpec{Name: "winrm_use_ntlm", Type: cty.Bool, Required: false},
 		"skip_create_image":                      &hcldec.AttrSpec{Name: "skip_create_image", Type: cty.Bool, Required: false},
+		"dry_run":                                &hcldec.AttrSpec{Name: "dry_run", Type: cty.Bool, Required: false},
+		"plan_output":                            &hcldec.AttrSpec{Name: "plan_output", Type: cty.String, Required: false},
+		"windows_update":                         &hcldec.AttrSpec{Name: "windows_update", Type: cty.Bool, Required: false},
+		"update_agent_before_capture":            &hcldec.AttrSpec{Name: "update_agent_before_capture", Type: cty.Bool, Required: false},
+		"optimize_applications":                  &hcldec.AttrSpec{Name: "optimize_applications", Type: cty.Bool, Required: false},
+		"optimize_applications_wait":             &hcldec.AttrSpec{Name: "optimize_applications_wait", Type: cty.String, Required: false},
+		"template_user":                          &hcldec.AttrSpec{Name: "template_user", Type: cty.String, Required: false},
+		"restart_timeout":                        &hcldec.AttrSpec{Name: "restart_timeout", Type: cty.String, Required: false},
+		"manifest_output":                        &hcldec.AttrSpec{Name: "manifest_output", Type: cty.String, Required: false},
 		"name":                                   &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
 		"builder_name":                           &hcldec.AttrSpec{Name: "builder_name", Type: cty.String, Required: false},
 		"description":                            &hcldec.AttrSpec{Name: "description", Type: cty.String, Required: false},
@@ -1,8 +1,23 @@
 package appstream
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appstream"
+	"github.com/aws/aws-sdk-go-v2/service/appstream/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
@@ -24,6 +39,43 @@ func TestBuilder_Prepare(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "plan_output without dry_run warns",
+			config: map[string]any{
+				"name":              "test-builder",
+				"source_image_name": "test-image",
+				"instance_type":     "stream.standard.small",
+				"communicator":      "winrm",
+				"winrm_username":    "Administrator",
+				"plan_output":       "plan.json",
+			},
+			wantErr:   false,
+			wantWarns: true,
+		},
+		{
+			name: "organizational unit without directory",
+			config: map[string]any{
+				"name":                                   "test-builder",
+				"source_image_name":                      "test-image",
+				"instance_type":                          "stream.standard.small",
+				"communicator":                           "winrm",
+				"winrm_username":                         "Administrator",
+				"organizational_unit_distinguished_name": "OU=AppStream,DC=example,DC=com",
+			},
+			wantErr: true,
+		},
+		{
+			name: "computer account cleanup without directory",
+			config: map[string]any{
+				"name":                     "test-builder",
+				"source_image_name":        "test-image",
+				"instance_type":            "stream.standard.small",
+				"communicator":             "winrm",
+				"winrm_username":           "Administrator",
+				"cleanup_computer_account": true,
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -45,3 +97,277 @@ func TestBuilder_Prepare(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateImageCommand_SortsTags(t *testing.T) {
+	c := &Config{
+		Name: "my-image",
+		Tags: map[string]string{"b": "2", "a": "1", "c": "3"},
+	}
+
+	want := `image-assistant.exe create-image --name my-image --tags "a" "1" "b" "2" "c" "3"`
+	for range 10 {
+		if got := createImageCommand(c); got != want {
+			t.Fatalf("createImageCommand() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestWriteManifest_FromState(t *testing.T) {
+	c := &Config{
+		Name:            "my-image",
+		BuilderName:     "my-builder",
+		SourceImageName: "base-image",
+		Tags:            map[string]string{"team": "devops"},
+	}
+	c.RawRegion = "us-east-1"
+
+	state := new(multistep.BasicStateBag)
+	state.Put("ip", "10.0.0.10")
+	state.Put("image_builder", &types.ImageBuilder{
+		Arn:                   aws.String("arn:aws:appstream:us-east-1:111111111111:image-builder/my-builder"),
+		ImageArn:              aws.String("arn:aws:appstream:us-east-1::image/base-image"),
+		AppstreamAgentVersion: aws.String("1.1.1"),
+	})
+	state.Put("image", &types.Image{
+		Arn: aws.String("arn:aws:appstream:us-east-1:111111111111:image/my-image"),
+		Applications: []types.Application{
+			{Name: aws.String("notepad"), LaunchPath: aws.String(`C:\Windows\notepad.exe`)},
+		},
+	})
+
+	start := time.Now()
+	manifest := buildManifest(c, state, start, start.Add(time.Hour))
+
+	tmp := t.TempDir() + "/manifest.json"
+	if err := writeManifest(tmp, manifest); err != nil {
+		t.Fatalf("writeManifest failed: %v", err)
+	}
+
+	data, err := os.ReadFile(tmp)
+	if err != nil {
+		t.Fatalf("reading manifest file failed: %v", err)
+	}
+
+	var out BuildManifest
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	if out.Images["us-east-1"] != "arn:aws:appstream:us-east-1:111111111111:image/my-image" {
+		t.Fatalf("unexpected images: %v", out.Images)
+	}
+	if out.IP != "10.0.0.10" || out.AppstreamAgentVersion != "1.1.1" || out.SourceImageArn == "" {
+		t.Fatalf("manifest content mismatch: %+v", out)
+	}
+	if len(out.Applications) != 1 || out.Applications[0].Name != "notepad" {
+		t.Fatalf("unexpected applications: %+v", out.Applications)
+	}
+}
+
+func TestParseWindowsUpdateOutput(t *testing.T) {
+	output := "KB:KB5034441\r\nKB:KB890830\r\nREBOOT_REQUIRED\r\nDONE\r\n"
+
+	kbs, reboot := parseWindowsUpdateOutput(output)
+	if !slices.Equal(kbs, []string{"KB5034441", "KB890830"}) {
+		t.Fatalf("unexpected kbs: %v", kbs)
+	}
+	if !reboot {
+		t.Fatalf("expected reboot to be required")
+	}
+
+	kbs, reboot = parseWindowsUpdateOutput("DONE\r\n")
+	if len(kbs) != 0 || reboot {
+		t.Fatalf("expected no updates and no reboot, got %v %t", kbs, reboot)
+	}
+}
+
+func TestPowershellCommand_EncodesUTF16LE(t *testing.T) {
+	cmd := powershellCommand("hi")
+
+	encoded := strings.TrimPrefix(cmd, "powershell.exe -NoProfile -ExecutionPolicy Bypass -EncodedCommand ")
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decoding failed: %v", err)
+	}
+	if string(raw) != "h\x00i\x00" {
+		t.Fatalf("unexpected encoding: %q", raw)
+	}
+}
+
+func TestParseListApplications(t *testing.T) {
+	output := []byte(`{"status": 0, "message": "Success", "applications": [{"Name": "notepad", "AbsoluteAppPath": "C:\\Windows\\notepad.exe"}]}`)
+
+	apps, err := parseListApplications(output)
+	if err != nil {
+		t.Fatalf("parseListApplications failed: %v", err)
+	}
+	if len(apps) != 1 || apps[0].Name != "notepad" || apps[0].AbsoluteAppPath != `C:\Windows\notepad.exe` {
+		t.Fatalf("unexpected applications: %+v", apps)
+	}
+
+	if _, err := parseListApplications([]byte(`{"status": 1, "message": "Access denied"}`)); err == nil {
+		t.Fatalf("expected error for failed status")
+	}
+}
+
+func TestOptimizeApplicationScript(t *testing.T) {
+	app := imageAssistantApplication{
+		Name:            "o'brien",
+		AbsoluteAppPath: `C:\Program Files\App\app.exe`,
+	}
+
+	script := optimizeApplicationScript(app, "ImageBuilderTemplateUser", 90*time.Second)
+
+	for _, want := range []string{
+		`-Execute 'C:\Program Files\App\app.exe'`,
+		`-UserId 'ImageBuilderTemplateUser'`,
+		`-TaskName 'packer-optimize-o''brien'`,
+		"Start-Sleep -Seconds 90",
+		"Test-Path -Path '" + defaultOptimizationManifestPath + "'",
+		// The default manifest is shared, it must list the application
+		"Select-String -Path '" + defaultOptimizationManifestPath + `' -Pattern 'C:\Program Files\App\app.exe' -SimpleMatch`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Fatalf("script missing %q:\n%s", want, script)
+		}
+	}
+
+	app.AbsoluteManifestPath = `C:\App\manifest.txt`
+	script = optimizeApplicationScript(app, "ImageBuilderTemplateUser", 90*time.Second)
+	if !strings.Contains(script, `Test-Path -Path 'C:\App\manifest.txt'`) || strings.Contains(script, "Select-String") {
+		t.Fatalf("expected only the application manifest to be checked:\n%s", script)
+	}
+}
+
+func TestImageBuilderInput_DomainJoinInfo(t *testing.T) {
+	c := &Config{BuilderName: "my-builder"}
+	if input := imageBuilderInput(c); input.DomainJoinInfo != nil {
+		t.Fatalf("expected no DomainJoinInfo without a directory, got %+v", input.DomainJoinInfo)
+	}
+
+	c.DirectoryName = aws.String("corp.example.com")
+	c.OrganizationalUnitDistinguishedName = aws.String("OU=AppStream,DC=corp,DC=example,DC=com")
+	input := imageBuilderInput(c)
+	if input.DomainJoinInfo == nil || aws.ToString(input.DomainJoinInfo.DirectoryName) != "corp.example.com" {
+		t.Fatalf("expected DomainJoinInfo for corp.example.com, got %+v", input.DomainJoinInfo)
+	}
+}
+
+func TestResolvePlan(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "" {
+			var input map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+				t.Errorf("decoding request: %v", err)
+			}
+			if target != "PhotonAdminProxyService.DescribeImages" {
+				t.Errorf("unexpected %s request", target)
+			}
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			if input["Type"] == "PUBLIC" {
+				fmt.Fprint(w, `{"Images":[{"Name":"a","AppstreamAgentVersion":"12-19-2023"},{"Name":"b","AppstreamAgentVersion":"10-02-2024"},{"Name":"c"}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"Images":[{"Name":"base","Arn":"arn:aws:appstream:us-east-1::image/base","Platform":"WINDOWS_SERVER_2022","AppstreamAgentVersion":"12-19-2023"}]}`)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing request: %v", err)
+		}
+		if action := r.Form.Get("Action"); action != "DescribeSubnets" {
+			t.Errorf("unexpected %s request", action)
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, `<DescribeSubnetsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>test</requestId>
+			<subnetSet><item><subnetId>subnet-1</subnetId><vpcId>vpc-1</vpcId><availabilityZone>us-east-1a</availabilityZone></item></subnetSet>
+		</DescribeSubnetsResponse>`)
+	}))
+	defer server.Close()
+
+	cfg := aws.Config{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		BaseEndpoint: aws.String(server.URL),
+	}
+	c := &Config{
+		Name:                     "my-image",
+		BuilderName:              "my-builder",
+		SourceImageName:          "base",
+		AppstreamAgentVersion:    "LATEST",
+		SubnetIds:                []string{"subnet-1"},
+		WindowsUpdate:            true,
+		UpdateAgentBeforeCapture: true,
+		OptimizeApplications:     true,
+		OptimizeApplicationsWait: time.Minute,
+		TemplateUser:             "ImageBuilderTemplateUser",
+		CleanupComputerAccount:   true,
+	}
+
+	plan, err := resolvePlan(context.Background(), c, "us-east-1", appstream.NewFromConfig(cfg), ec2.NewFromConfig(cfg))
+	if err != nil {
+		t.Fatalf("resolvePlan failed: %v", err)
+	}
+	if plan.AppstreamAgentVersion != "10-02-2024" {
+		t.Fatalf("expected LATEST to resolve to 10-02-2024, got %q", plan.AppstreamAgentVersion)
+	}
+	if plan.SourceImage.Arn != "arn:aws:appstream:us-east-1::image/base" || plan.SourceImage.AppstreamAgentVersion != "12-19-2023" {
+		t.Fatalf("unexpected source image %+v", plan.SourceImage)
+	}
+	if len(plan.Subnets) != 1 || plan.Subnets[0].VpcId != "vpc-1" {
+		t.Fatalf("unexpected subnets %+v", plan.Subnets)
+	}
+
+	want := []string{
+		windowsUpdateRunner,
+		ec2LaunchInstallScript,
+		"StopImageBuilder, then StartImageBuilder on AppStream agent LATEST",
+		"image-assistant.exe list-applications",
+	}
+	if len(plan.Commands) != 7 || !slices.Equal(plan.Commands[:4], want) {
+		t.Fatalf("unexpected commands %q", plan.Commands)
+	}
+	if !strings.Contains(plan.Commands[4], "'ImageBuilderTemplateUser'") ||
+		plan.Commands[5] != computerAccountCleanupScript ||
+		plan.Commands[6] != "image-assistant.exe create-image --name my-image" {
+		t.Fatalf("unexpected commands %q", plan.Commands[4:])
+	}
+}
+
+func TestWaitForImageBuilderState(t *testing.T) {
+	for _, tt := range []struct {
+		state string
+		err   string
+	}{
+		{state: "RUNNING"},
+		{state: "FAILED", err: "bad imagebuilder state: FAILED: no capacity"},
+		{state: "PENDING", err: "timed out after 20ms waiting for ImageBuilder (my-builder) to become RUNNING (state: PENDING)"},
+	} {
+		t.Run(tt.state, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if target := r.Header.Get("X-Amz-Target"); target != "PhotonAdminProxyService.DescribeImageBuilders" {
+					t.Errorf("unexpected %s request", target)
+				}
+				w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+				fmt.Fprintf(w, `{"ImageBuilders":[{"Name":"my-builder","State":%q,"ImageBuilderErrors":[{"ErrorMessage":"no capacity"}]}]}`, tt.state)
+			}))
+			defer server.Close()
+			svc := appstream.NewFromConfig(aws.Config{
+				Region:       "us-east-1",
+				Credentials:  aws.AnonymousCredentials{},
+				BaseEndpoint: aws.String(server.URL),
+			})
+
+			builder, err := waitForImageBuilderState(context.Background(), svc, packersdk.TestUi(t), "my-builder", types.ImageBuilderStateRunning, 20*time.Millisecond)
+			if tt.err == "" {
+				if err != nil || builder.State != types.ImageBuilderStateRunning {
+					t.Fatalf("expected a running builder, got %+v (%v)", builder, err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
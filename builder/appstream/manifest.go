@@ -0,0 +1,88 @@
+package appstream
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appstream/types"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+// BuildManifest holds the data about a finished AppStream build.
+type BuildManifest struct {
+	SourceImage           string    `json:"source_image"`
+	SourceImageArn        string    `json:"source_image_arn,omitempty"`
+	BuilderName           string    `json:"builder_name"`
+	BuilderArn            string    `json:"builder_arn,omitempty"`
+	IP                    string    `json:"ip,omitempty"`
+	AppstreamAgentVersion string    `json:"appstream_agent_version,omitempty"`
+	StartTime             time.Time `json:"start_time"`
+	EndTime               time.Time `json:"end_time"`
+	ImageName             string    `json:"image_name"`
+	// A map of regions to the ARNs of the created images.
+	Images       map[string]string     `json:"images"`
+	Tags         map[string]string     `json:"tags,omitempty"`
+	Applications []ManifestApplication `json:"applications,omitempty"`
+	// The KBs installed by `windows_update`.
+	InstalledUpdates []string `json:"installed_updates,omitempty"`
+}
+
+// ManifestApplication is an application registered in the created image.
+type ManifestApplication struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name,omitempty"`
+	LaunchPath  string `json:"launch_path,omitempty"`
+}
+
+// buildManifest assembles a BuildManifest from the state of a finished build.
+func buildManifest(c *Config, state multistep.StateBag, start, end time.Time) *BuildManifest {
+	manifest := &BuildManifest{
+		SourceImage:           c.SourceImageName,
+		BuilderName:           c.BuilderName,
+		AppstreamAgentVersion: c.AppstreamAgentVersion,
+		StartTime:             start,
+		EndTime:               end,
+		ImageName:             c.Name,
+		Images:                map[string]string{},
+		Tags:                  c.Tags,
+	}
+
+	if builder, ok := state.Get("image_builder").(*types.ImageBuilder); ok {
+		manifest.SourceImageArn = aws.ToString(builder.ImageArn)
+		manifest.BuilderArn = aws.ToString(builder.Arn)
+		if builder.AppstreamAgentVersion != nil {
+			manifest.AppstreamAgentVersion = *builder.AppstreamAgentVersion
+		}
+	}
+
+	if ip, ok := state.Get("ip").(string); ok {
+		manifest.IP = ip
+	}
+
+	if image, ok := state.Get("image").(*types.Image); ok {
+		manifest.Images[c.RawRegion] = aws.ToString(image.Arn)
+		for _, app := range image.Applications {
+			manifest.Applications = append(manifest.Applications, ManifestApplication{
+				Name:        aws.ToString(app.Name),
+				DisplayName: aws.ToString(app.DisplayName),
+				LaunchPath:  aws.ToString(app.LaunchPath),
+			})
+		}
+	}
+
+	if kbs, ok := state.Get("installed_updates").([]string); ok {
+		manifest.InstalledUpdates = kbs
+	}
+
+	return manifest
+}
+
+func writeManifest(output string, manifest *BuildManifest) error {
+	rawManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, rawManifest, 0644)
+}
@@ -0,0 +1,99 @@
+package appstream
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// computerAccountCleanupScript removes the computer object of the machine it
+// runs on from Active Directory.
+const computerAccountCleanupScript = `$ErrorActionPreference = 'Stop'
+$result = ([ADSISearcher]"(&(objectCategory=computer)(sAMAccountName=$env:COMPUTERNAME$))").FindOne()
+if ($result) { $result.GetDirectoryEntry().DeleteTree(); Write-Output "Removed computer account $env:COMPUTERNAME" }
+`
+
+// StepComputerAccountCleanup removes the AD computer object created when the
+// ImageBuilder joined the directory. It has to run before the image is
+// captured, as the ImageBuilder can no longer be reached afterwards. A second
+// instance with onFailure set is placed right after connecting, so that the
+// computer object is also removed when the build fails before the capture.
+type StepComputerAccountCleanup struct {
+	config    Config
+	onFailure bool
+}
+
+var _ multistep.Step = new(StepComputerAccountCleanup)
+
+func (s *StepComputerAccountCleanup) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if s.onFailure {
+		return multistep.ActionContinue
+	}
+
+	ui, ok := state.Get("ui").(packersdk.Ui)
+	if !ok {
+		state.Put("error", fmt.Errorf("ui not found"))
+		return multistep.ActionHalt
+	}
+	comm, ok := state.Get("communicator").(packersdk.Communicator)
+	if !ok {
+		state.Put("error", fmt.Errorf("communicator not found"))
+		return multistep.ActionHalt
+	}
+
+	if err := s.cleanup(ctx, comm, ui); err != nil {
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+	state.Put("computer_account_removed", true)
+
+	return multistep.ActionContinue
+}
+
+func (s *StepComputerAccountCleanup) Cleanup(state multistep.StateBag) {
+	if !s.onFailure {
+		return
+	}
+	if _, ok := state.GetOk("computer_account_removed"); ok {
+		return
+	}
+
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if !cancelled && !halted {
+		return
+	}
+
+	ui := state.Get("ui").(packersdk.Ui)
+	comm, ok := state.Get("communicator").(packersdk.Communicator)
+	if !ok {
+		return
+	}
+
+	if err := s.cleanup(context.TODO(), comm, ui); err != nil {
+		ui.Error(fmt.Sprintf("Error removing computer account, may still be around: %s", err))
+	}
+}
+
+func (s *StepComputerAccountCleanup) cleanup(ctx context.Context, comm packersdk.Communicator, ui packersdk.Ui) error {
+	ui.Say("Removing the ImageBuilder computer account from the directory...")
+
+	command := s.config.ComputerAccountCleanupCommand
+	if command == "" {
+		command = powershellCommand(computerAccountCleanupScript)
+	}
+
+	cmd := &packersdk.RemoteCmd{
+		Command: command,
+	}
+	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+		return fmt.Errorf("failed to remove computer account: %w", err)
+	}
+	if cmd.ExitStatus() != 0 {
+		return fmt.Errorf("computer account cleanup failed with exit status: %d", cmd.ExitStatus())
+	}
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package appstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appstream"
+	"github.com/aws/aws-sdk-go-v2/service/appstream/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// Plan describes everything the builder would do for a given configuration.
+type Plan struct {
+	Region                  string                             `json:"region"`
+	SourceImage             PlanSourceImage                    `json:"source_image"`
+	Subnets                 []PlanSubnet                       `json:"subnets,omitempty"`
+	SecurityGroups          []PlanSecurityGroup                `json:"security_groups,omitempty"`
+	Directory               *PlanDirectory                     `json:"directory,omitempty"`
+	AppstreamAgentVersion   string                             `json:"appstream_agent_version"`
+	CreateImageBuilderInput *appstream.CreateImageBuilderInput `json:"create_image_builder_input"`
+	Commands                []string                           `json:"commands"`
+	// A map of regions to the names of the images that would be created.
+	Images map[string]string `json:"images"`
+}
+
+// PlanSourceImage is the resolved source image.
+type PlanSourceImage struct {
+	Name                  string `json:"name"`
+	Arn                   string `json:"arn"`
+	Platform              string `json:"platform"`
+	AppstreamAgentVersion string `json:"appstream_agent_version,omitempty"`
+}
+
+// PlanSubnet is a resolved subnet.
+type PlanSubnet struct {
+	SubnetId         string `json:"subnet_id"`
+	VpcId            string `json:"vpc_id"`
+	AvailabilityZone string `json:"availability_zone"`
+}
+
+// PlanSecurityGroup is a resolved security group.
+type PlanSecurityGroup struct {
+	GroupId   string `json:"group_id"`
+	GroupName string `json:"group_name"`
+	VpcId     string `json:"vpc_id"`
+}
+
+// PlanDirectory is the resolved domain-join directory.
+type PlanDirectory struct {
+	DirectoryName                        string   `json:"directory_name"`
+	OrganizationalUnitDistinguishedName  string   `json:"organizational_unit_distinguished_name,omitempty"`
+	OrganizationalUnitDistinguishedNames []string `json:"organizational_unit_distinguished_names"`
+}
+
+// StepDryRun resolves every input of the build and reports the resulting plan
+// without creating anything.
+type StepDryRun struct {
+	config Config
+}
+
+var _ multistep.Step = new(StepDryRun)
+
+func (s *StepDryRun) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	svc, ok := state.Get("appstreamv2").(*appstream.Client)
+	if !ok {
+		state.Put("error", fmt.Errorf("appstreamv2 client not found"))
+		return multistep.ActionHalt
+	}
+	cfg, ok := state.Get("aws_config").(*aws.Config)
+	if !ok {
+		state.Put("error", fmt.Errorf("aws_config not found"))
+		return multistep.ActionHalt
+	}
+	ui, ok := state.Get("ui").(packersdk.Ui)
+	if !ok {
+		state.Put("error", fmt.Errorf("ui not found"))
+		return multistep.ActionHalt
+	}
+
+	ui.Say("Resolving build plan (dry_run is true, nothing will be created)...")
+
+	plan, err := resolvePlan(ctx, &s.config, cfg.Region, svc, ec2.NewFromConfig(*cfg))
+	if err != nil {
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	raw, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		state.Put("error", fmt.Errorf("failed to marshal plan: %w", err))
+		return multistep.ActionHalt
+	}
+
+	ui.Say(fmt.Sprintf("CreateImageBuilder request:\n%s", prettyJSON(plan.CreateImageBuilderInput)))
+	for _, cmd := range plan.Commands {
+		ui.Say(fmt.Sprintf("Would execute command: %s", cmd))
+	}
+
+	if s.config.PlanOutput != "" {
+		if err := os.WriteFile(s.config.PlanOutput, raw, 0644); err != nil {
+			state.Put("error", fmt.Errorf("failed to write plan to %s: %w", s.config.PlanOutput, err))
+			return multistep.ActionHalt
+		}
+		ui.Say(fmt.Sprintf("Plan written to %s", s.config.PlanOutput))
+	}
+
+	state.Put("plan", plan)
+
+	return multistep.ActionContinue
+}
+
+func (s *StepDryRun) Cleanup(multistep.StateBag) {
+	// Nothing was created
+}
+
+// resolvePlan looks up every resource referenced by the config and assembles the Plan.
+func resolvePlan(ctx context.Context, c *Config, region string, svc *appstream.Client, ec2conn *ec2.Client) (*Plan, error) {
+	plan := &Plan{
+		Region:                  region,
+		CreateImageBuilderInput: imageBuilderInput(c),
+		Commands:                planCommands(c),
+		Images:                  map[string]string{region: c.Name},
+	}
+
+	version, err := resolveAgentVersion(ctx, svc, c.AppstreamAgentVersion)
+	if err != nil {
+		return nil, err
+	}
+	plan.AppstreamAgentVersion = version
+
+	images, err := svc.DescribeImages(ctx, &appstream.DescribeImagesInput{
+		Names: []string{c.SourceImageName},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe source image %s: %w", c.SourceImageName, err)
+	}
+	if len(images.Images) == 0 {
+		return nil, fmt.Errorf("source image %s not found", c.SourceImageName)
+	}
+	source := images.Images[0]
+	plan.SourceImage = PlanSourceImage{
+		Name:                  c.SourceImageName,
+		Arn:                   aws.ToString(source.Arn),
+		Platform:              string(source.Platform),
+		AppstreamAgentVersion: aws.ToString(source.AppstreamAgentVersion),
+	}
+
+	if len(c.SubnetIds) > 0 {
+		out, err := ec2conn.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+			SubnetIds: c.SubnetIds,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe subnets: %w", err)
+		}
+		for _, subnet := range out.Subnets {
+			plan.Subnets = append(plan.Subnets, PlanSubnet{
+				SubnetId:         aws.ToString(subnet.SubnetId),
+				VpcId:            aws.ToString(subnet.VpcId),
+				AvailabilityZone: aws.ToString(subnet.AvailabilityZone),
+			})
+		}
+	}
+
+	if len(c.SecurityGroupIds) > 0 {
+		out, err := ec2conn.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+			GroupIds: c.SecurityGroupIds,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe security groups: %w", err)
+		}
+		for _, sg := range out.SecurityGroups {
+			plan.SecurityGroups = append(plan.SecurityGroups, PlanSecurityGroup{
+				GroupId:   aws.ToString(sg.GroupId),
+				GroupName: aws.ToString(sg.GroupName),
+				VpcId:     aws.ToString(sg.VpcId),
+			})
+		}
+	}
+
+	if c.DirectoryName != nil {
+		directory, err := describeDirectoryConfig(ctx, svc, c)
+		if err != nil {
+			return nil, err
+		}
+		plan.Directory = &PlanDirectory{
+			DirectoryName:                        *c.DirectoryName,
+			OrganizationalUnitDistinguishedName:  aws.ToString(c.OrganizationalUnitDistinguishedName),
+			OrganizationalUnitDistinguishedNames: directory.OrganizationalUnitDistinguishedNames,
+		}
+	}
+
+	return plan, nil
+}
+
+// planCommands returns what would be run on the ImageBuilder after
+// provisioning, in the order of the build steps. PowerShell scripts are listed
+// as scripts rather than as their encoded command line.
+func planCommands(c *Config) []string {
+	var commands []string
+	if c.WindowsUpdate {
+		commands = append(commands, windowsUpdateRunner)
+	}
+	if c.UpdateAgentBeforeCapture {
+		commands = append(commands, ec2LaunchInstallScript,
+			"StopImageBuilder, then StartImageBuilder on AppStream agent LATEST")
+	}
+	if c.OptimizeApplications {
+		app := imageAssistantApplication{Name: "<name>", AbsoluteAppPath: "<path>"}
+		commands = append(commands, "image-assistant.exe list-applications",
+			optimizeApplicationScript(app, c.TemplateUser, c.OptimizeApplicationsWait))
+	}
+	if c.CleanupComputerAccount {
+		if c.ComputerAccountCleanupCommand != "" {
+			commands = append(commands, c.ComputerAccountCleanupCommand)
+		} else {
+			commands = append(commands, computerAccountCleanupScript)
+		}
+	}
+	return append(commands, createImageCommand(c))
+}
+
+// resolveAgentVersion resolves LATEST to the newest AppStream agent of the
+// images published by AWS, since AppStream has no API to look it up directly.
+// Other versions are returned as is.
+func resolveAgentVersion(ctx context.Context, svc *appstream.Client, version string) (string, error) {
+	if version != "LATEST" {
+		return version, nil
+	}
+
+	var latest time.Time
+	paginator := appstream.NewDescribeImagesPaginator(svc, &appstream.DescribeImagesInput{
+		Type: types.VisibilityTypePublic,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve the latest AppStream agent version: %w", err)
+		}
+		for _, image := range page.Images {
+			// Agent versions are release dates, e.g. 10-02-2024
+			released, err := time.Parse("01-02-2006", aws.ToString(image.AppstreamAgentVersion))
+			if err == nil && released.After(latest) {
+				latest = released
+			}
+		}
+	}
+	if latest.IsZero() {
+		return "", fmt.Errorf("failed to resolve the latest AppStream agent version: no public image reports one")
+	}
+	return latest.Format("01-02-2006"), nil
+}
+
+// prettyJSON renders v as indented JSON for display.
+func prettyJSON(v any) string {
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%+v", v)
+	}
+	return string(raw)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/appstream"
@@ -31,29 +33,17 @@ func (s *StepImageBuilderCreate) Run(ctx context.Context, state multistep.StateB
 		return multistep.ActionHalt
 	}
 
+	if s.config.DirectoryName != nil {
+		ui.Say(fmt.Sprintf("Validating directory config %s...", *s.config.DirectoryName))
+		if _, err := describeDirectoryConfig(ctx, svc, &s.config); err != nil {
+			state.Put("error", err)
+			return multistep.ActionHalt
+		}
+	}
+
 	ui.Say("Launching an AppStream ImageBuilder...")
 
-	out, err := svc.CreateImageBuilder(ctx, &appstream.CreateImageBuilderInput{
-		Name:                        &s.config.BuilderName,
-		Description:                 &s.config.Description,
-		DisplayName:                 &s.config.DisplayName,
-		InstanceType:                &s.config.InstanceType,
-		IamRoleArn:                  &s.config.IamRoleArn,
-		ImageName:                   &s.config.SourceImageName,
-		EnableDefaultInternetAccess: &s.config.EnableDefaultInternetAccess,
-		AppstreamAgentVersion:       &s.config.AppstreamAgentVersion,
-		DomainJoinInfo: &types.DomainJoinInfo{
-			DirectoryName:                       s.config.DirectoryName,
-			OrganizationalUnitDistinguishedName: s.config.OrganizationalUnitDistinguishedName,
-		},
-		VpcConfig: &types.VpcConfig{
-			SecurityGroupIds: s.config.SecurityGroupIds,
-			SubnetIds:        s.config.SubnetIds,
-		},
-		Tags:                 s.config.BuilderTags,
-		SoftwaresToInstall:   s.config.SoftwaresToInstall,
-		SoftwaresToUninstall: s.config.SoftwaresToUninstall,
-	})
+	out, err := svc.CreateImageBuilder(ctx, imageBuilderInput(&s.config))
 	if err != nil {
 		state.Put("error", err)
 		return multistep.ActionHalt
@@ -109,6 +99,66 @@ func (s *StepImageBuilderCreate) Run(ctx context.Context, state multistep.StateB
 	return multistep.ActionContinue
 }
 
+// imageBuilderInput assembles the CreateImageBuilder request for the given config.
+func imageBuilderInput(c *Config) *appstream.CreateImageBuilderInput {
+	return &appstream.CreateImageBuilderInput{
+		Name:                        &c.BuilderName,
+		Description:                 &c.Description,
+		DisplayName:                 &c.DisplayName,
+		InstanceType:                &c.InstanceType,
+		IamRoleArn:                  &c.IamRoleArn,
+		ImageName:                   &c.SourceImageName,
+		EnableDefaultInternetAccess: &c.EnableDefaultInternetAccess,
+		AppstreamAgentVersion:       &c.AppstreamAgentVersion,
+		DomainJoinInfo:              domainJoinInfo(c),
+		VpcConfig: &types.VpcConfig{
+			SecurityGroupIds: c.SecurityGroupIds,
+			SubnetIds:        c.SubnetIds,
+		},
+		Tags:                 c.BuilderTags,
+		SoftwaresToInstall:   c.SoftwaresToInstall,
+		SoftwaresToUninstall: c.SoftwaresToUninstall,
+	}
+}
+
+// domainJoinInfo returns the DomainJoinInfo for the config, or nil when the
+// ImageBuilder should not be joined to a directory.
+func domainJoinInfo(c *Config) *types.DomainJoinInfo {
+	if c.DirectoryName == nil {
+		return nil
+	}
+	return &types.DomainJoinInfo{
+		DirectoryName:                       c.DirectoryName,
+		OrganizationalUnitDistinguishedName: c.OrganizationalUnitDistinguishedName,
+	}
+}
+
+// describeDirectoryConfig looks up the configured directory and validates the
+// organizational unit against the ones registered with it.
+func describeDirectoryConfig(ctx context.Context, svc *appstream.Client, c *Config) (*types.DirectoryConfig, error) {
+	out, err := svc.DescribeDirectoryConfigs(ctx, &appstream.DescribeDirectoryConfigsInput{
+		DirectoryNames: []string{*c.DirectoryName},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe directory config %s: %w", *c.DirectoryName, err)
+	}
+	if len(out.DirectoryConfigs) == 0 {
+		return nil, fmt.Errorf("directory config %s not found", *c.DirectoryName)
+	}
+
+	directory := out.DirectoryConfigs[0]
+	if ou := c.OrganizationalUnitDistinguishedName; ou != nil {
+		if !slices.ContainsFunc(directory.OrganizationalUnitDistinguishedNames, func(s string) bool {
+			return strings.EqualFold(s, *ou)
+		}) {
+			return nil, fmt.Errorf("organizational unit %q is not registered with directory config %s (registered: %s)",
+				*ou, *c.DirectoryName, strings.Join(directory.OrganizationalUnitDistinguishedNames, "; "))
+		}
+	}
+
+	return &directory, nil
+}
+
 func (s *StepImageBuilderCreate) Cleanup(state multistep.StateBag) {
 	svc := state.Get("appstreamv2").(*appstream.Client)
 	ui := state.Get("ui").(packersdk.Ui)
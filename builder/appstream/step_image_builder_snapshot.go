@@ -3,6 +3,8 @@ package appstream
 import (
 	"context"
 	"fmt"
+	"maps"
+	"slices"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/appstream"
@@ -43,13 +45,7 @@ func (s *StepImageBuilderSnapshot) Run(ctx context.Context, state multistep.Stat
 	ui.Say("Capturing the AppStream Image...")
 
 	// Construct the command to create the image
-	cmdString := fmt.Sprintf("image-assistant.exe create-image --name %s", s.config.Name)
-	if len(s.config.Tags) > 0 {
-		cmdString += " --tags"
-		for k, v := range s.config.Tags {
-			cmdString += fmt.Sprintf(" %q %q", k, v)
-		}
-	}
+	cmdString := createImageCommand(&s.config)
 
 	ui.Say(fmt.Sprintf("Executing command: %s", cmdString))
 
@@ -92,6 +88,7 @@ func (s *StepImageBuilderSnapshot) Run(ctx context.Context, state multistep.Stat
 
 		switch images.Images[0].State {
 		case types.ImageStateAvailable:
+			state.Put("image", &images.Images[0])
 			state.Put("images", map[string]string{
 				s.config.RawRegion: s.config.Name,
 			})
@@ -116,6 +113,19 @@ func (s *StepImageBuilderSnapshot) Run(ctx context.Context, state multistep.Stat
 	}
 }
 
+// createImageCommand returns the image-assistant command used to capture the image.
+// Tags are emitted in sorted order so the command is stable between runs.
+func createImageCommand(c *Config) string {
+	cmdString := fmt.Sprintf("image-assistant.exe create-image --name %s", c.Name)
+	if len(c.Tags) > 0 {
+		cmdString += " --tags"
+		for _, k := range slices.Sorted(maps.Keys(c.Tags)) {
+			cmdString += fmt.Sprintf(" %q %q", k, c.Tags[k])
+		}
+	}
+	return cmdString
+}
+
 func (s *StepImageBuilderSnapshot) Cleanup(multistep.StateBag) {
 	// Nothing to do
 }
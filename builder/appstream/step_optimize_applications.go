@@ -0,0 +1,159 @@
+package appstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// The optimization manifest written by AppStream for applications registered
+// without an explicit manifest path.
+const defaultOptimizationManifestPath = `C:\ProgramData\Amazon\Photon\Prewarm\PrewarmManifest.txt`
+
+// imageAssistantApplication is an application as reported by
+// `image-assistant.exe list-applications`.
+type imageAssistantApplication struct {
+	Name                 string `json:"Name"`
+	DisplayName          string `json:"DisplayName"`
+	AbsoluteAppPath      string `json:"AbsoluteAppPath"`
+	AbsoluteManifestPath string `json:"AbsoluteManifestPath"`
+	WorkingDirectory     string `json:"WorkingDirectory"`
+	LaunchParameters     string `json:"LaunchParameters"`
+}
+
+type imageAssistantListOutput struct {
+	Status       int                         `json:"status"`
+	Message      string                      `json:"message"`
+	Applications []imageAssistantApplication `json:"applications"`
+}
+
+// StepOptimizeApplications launches every registered application once as the
+// Template User so that AppStream can optimize its launch performance.
+type StepOptimizeApplications struct {
+	config Config
+}
+
+var _ multistep.Step = new(StepOptimizeApplications)
+
+func (s *StepOptimizeApplications) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui, ok := state.Get("ui").(packersdk.Ui)
+	if !ok {
+		state.Put("error", fmt.Errorf("ui not found"))
+		return multistep.ActionHalt
+	}
+	comm, ok := state.Get("communicator").(packersdk.Communicator)
+	if !ok {
+		state.Put("error", fmt.Errorf("communicator not found"))
+		return multistep.ActionHalt
+	}
+
+	ui.Say("Optimizing application launch performance...")
+
+	var stdout bytes.Buffer
+	cmd := &packersdk.RemoteCmd{
+		Command: "image-assistant.exe list-applications",
+		Stdout:  &stdout,
+	}
+	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+		state.Put("error", fmt.Errorf("failed to list applications: %w", err))
+		return multistep.ActionHalt
+	}
+	if cmd.ExitStatus() != 0 {
+		state.Put("error", fmt.Errorf("listing applications failed with exit status: %d", cmd.ExitStatus()))
+		return multistep.ActionHalt
+	}
+
+	apps, err := parseListApplications(stdout.Bytes())
+	if err != nil {
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+	if len(apps) == 0 {
+		ui.Say("No applications registered, nothing to optimize")
+		return multistep.ActionContinue
+	}
+
+	for _, app := range apps {
+		ui.Say(fmt.Sprintf("Launching %s as %s for %s...", app.Name, s.config.TemplateUser, s.config.OptimizeApplicationsWait))
+
+		cmd := &packersdk.RemoteCmd{
+			Command: powershellCommand(optimizeApplicationScript(app, s.config.TemplateUser, s.config.OptimizeApplicationsWait)),
+		}
+		if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+			state.Put("error", fmt.Errorf("failed to optimize application %s: %w", app.Name, err))
+			return multistep.ActionHalt
+		}
+		if cmd.ExitStatus() != 0 {
+			state.Put("error", fmt.Errorf("optimizing application %s failed with exit status: %d", app.Name, cmd.ExitStatus()))
+			return multistep.ActionHalt
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Optimized %d applications", len(apps)))
+	return multistep.ActionContinue
+}
+
+func (s *StepOptimizeApplications) Cleanup(multistep.StateBag) {
+	// Nothing to do
+}
+
+// parseListApplications decodes the JSON output of `image-assistant.exe list-applications`.
+func parseListApplications(output []byte) ([]imageAssistantApplication, error) {
+	var out imageAssistantListOutput
+	if err := json.Unmarshal(output, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse list-applications output: %w", err)
+	}
+	if out.Status != 0 {
+		return nil, fmt.Errorf("list-applications failed: %s", out.Message)
+	}
+	return out.Applications, nil
+}
+
+// optimizeApplicationScript returns a PowerShell script that runs the
+// application as the given user through a scheduled task, stops it after the
+// wait and verifies that its optimization manifest exists. The default
+// manifest is shared by all the applications without one of their own, so it
+// must also list the application.
+func optimizeApplicationScript(app imageAssistantApplication, user string, wait time.Duration) string {
+	manifest := app.AbsoluteManifestPath
+	shared := manifest == ""
+	if shared {
+		manifest = defaultOptimizationManifestPath
+	}
+	task := "packer-optimize-" + app.Name
+
+	var b strings.Builder
+	b.WriteString("$ErrorActionPreference = 'Stop'\n")
+	fmt.Fprintf(&b, "$action = New-ScheduledTaskAction -Execute %s", psQuote(app.AbsoluteAppPath))
+	if app.LaunchParameters != "" {
+		fmt.Fprintf(&b, " -Argument %s", psQuote(app.LaunchParameters))
+	}
+	if app.WorkingDirectory != "" {
+		fmt.Fprintf(&b, " -WorkingDirectory %s", psQuote(app.WorkingDirectory))
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "$principal = New-ScheduledTaskPrincipal -UserId %s -LogonType S4U\n", psQuote(user))
+	fmt.Fprintf(&b, "Register-ScheduledTask -TaskName %s -Action $action -Principal $principal -Force | Out-Null\n", psQuote(task))
+	fmt.Fprintf(&b, "Start-ScheduledTask -TaskName %s\n", psQuote(task))
+	fmt.Fprintf(&b, "Start-Sleep -Seconds %d\n", int(wait.Seconds()))
+	fmt.Fprintf(&b, "Stop-ScheduledTask -TaskName %s\n", psQuote(task))
+	fmt.Fprintf(&b, "Unregister-ScheduledTask -TaskName %s -Confirm:$false\n", psQuote(task))
+	fmt.Fprintf(&b, "if (-not (Test-Path -Path %s)) { Write-Error %s }\n",
+		psQuote(manifest), psQuote(fmt.Sprintf("optimization manifest %s not found for %s", manifest, app.Name)))
+	if shared {
+		fmt.Fprintf(&b, "if (-not (Select-String -Path %s -Pattern %s -SimpleMatch -Quiet)) { Write-Error %s }\n",
+			psQuote(manifest), psQuote(app.AbsoluteAppPath), psQuote(fmt.Sprintf("%s not listed in optimization manifest %s", app.Name, manifest)))
+	}
+	return b.String()
+}
+
+// psQuote quotes s as a single-quoted PowerShell string literal.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
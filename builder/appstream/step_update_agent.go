@@ -0,0 +1,155 @@
+package appstream
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appstream"
+	"github.com/aws/aws-sdk-go-v2/service/appstream/types"
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// ec2LaunchInstallScript installs the latest release of EC2Launch v2.
+const ec2LaunchInstallScript = `$ErrorActionPreference = 'Stop'
+$msi = Join-Path $env:TEMP 'AmazonEC2Launch.msi'
+Invoke-WebRequest -UseBasicParsing -Uri 'https://s3.amazonaws.com/amazon-ec2launch-v2/windows/amd64/latest/AmazonEC2Launch.msi' -OutFile $msi
+$p = Start-Process -FilePath 'msiexec.exe' -ArgumentList "/i ""$msi"" /qn /norestart" -Wait -PassThru
+if ($p.ExitCode -notin 0, 3010) { exit $p.ExitCode }
+`
+
+// StepUpdateAgent updates EC2Launch on the ImageBuilder and restarts it on the
+// latest AppStream agent, reconnecting the communicator afterwards.
+type StepUpdateAgent struct {
+	config  Config
+	connect *communicator.StepConnect
+}
+
+var _ multistep.Step = new(StepUpdateAgent)
+
+func (s *StepUpdateAgent) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	svc, ok := state.Get("appstreamv2").(*appstream.Client)
+	if !ok {
+		state.Put("error", fmt.Errorf("appstreamv2 client not found"))
+		return multistep.ActionHalt
+	}
+	ui, ok := state.Get("ui").(packersdk.Ui)
+	if !ok {
+		state.Put("error", fmt.Errorf("ui not found"))
+		return multistep.ActionHalt
+	}
+	comm, ok := state.Get("communicator").(packersdk.Communicator)
+	if !ok {
+		state.Put("error", fmt.Errorf("communicator not found"))
+		return multistep.ActionHalt
+	}
+
+	ui.Say("Updating EC2Launch...")
+
+	cmd := &packersdk.RemoteCmd{
+		Command: powershellCommand(ec2LaunchInstallScript),
+	}
+	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+		state.Put("error", fmt.Errorf("failed to update EC2Launch: %w", err))
+		return multistep.ActionHalt
+	}
+	if cmd.ExitStatus() != 0 {
+		state.Put("error", fmt.Errorf("EC2Launch update failed with exit status: %d", cmd.ExitStatus()))
+		return multistep.ActionHalt
+	}
+
+	ui.Say("Restarting ImageBuilder on the latest AppStream agent...")
+
+	name := s.config.BuilderName
+	if _, err := svc.StopImageBuilder(ctx, &appstream.StopImageBuilderInput{Name: &name}); err != nil {
+		state.Put("error", fmt.Errorf("failed to stop ImageBuilder: %w", err))
+		return multistep.ActionHalt
+	}
+	if _, err := waitForImageBuilderState(ctx, svc, ui, name, types.ImageBuilderStateStopped, s.config.RestartTimeout); err != nil {
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	if _, err := svc.StartImageBuilder(ctx, &appstream.StartImageBuilderInput{
+		Name:                  &name,
+		AppstreamAgentVersion: aws.String("LATEST"),
+	}); err != nil {
+		state.Put("error", fmt.Errorf("failed to start ImageBuilder: %w", err))
+		return multistep.ActionHalt
+	}
+	builder, err := waitForImageBuilderState(ctx, svc, ui, name, types.ImageBuilderStateRunning, s.config.RestartTimeout)
+	if err != nil {
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	state.Put("image_builder", builder)
+	if builder.NetworkAccessConfiguration != nil && builder.NetworkAccessConfiguration.EniPrivateIpAddress != nil {
+		state.Put("ip", *builder.NetworkAccessConfiguration.EniPrivateIpAddress)
+	}
+	ui.Say(fmt.Sprintf("ImageBuilder is running AppStream agent %s", aws.ToString(builder.AppstreamAgentVersion)))
+
+	// The old connection does not survive the restart
+	return s.connect.Run(ctx, state)
+}
+
+func (s *StepUpdateAgent) Cleanup(multistep.StateBag) {
+	// Nothing to do
+}
+
+// waitForImageBuilderState polls the named ImageBuilder until it reaches the
+// desired state. It gives up once the ImageBuilder fails or is being deleted,
+// and after the timeout.
+func waitForImageBuilderState(ctx context.Context, svc *appstream.Client, ui packersdk.Ui, name string, want types.ImageBuilderState, timeout time.Duration) (*types.ImageBuilder, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var elapsed time.Duration
+	var state types.ImageBuilderState
+	for {
+		status, err := svc.DescribeImageBuilders(waitCtx, &appstream.DescribeImageBuildersInput{
+			Names: []string{name},
+		})
+		switch {
+		case err != nil && ctx.Err() == nil && waitCtx.Err() != nil:
+			return nil, fmt.Errorf("timed out after %s waiting for ImageBuilder (%s) to become %s (state: %s)", timeout, name, want, state)
+		case err != nil:
+			return nil, err
+		}
+		if len(status.ImageBuilders) == 0 {
+			return nil, fmt.Errorf("image builder not found")
+		}
+
+		imageBuilder := status.ImageBuilders[0]
+		state = imageBuilder.State
+		switch state {
+		case want:
+			return &imageBuilder, nil
+		case types.ImageBuilderStateFailed, types.ImageBuilderStateDeleting:
+			var reasons []string
+			for _, e := range imageBuilder.ImageBuilderErrors {
+				reasons = append(reasons, aws.ToString(e.ErrorMessage))
+			}
+			if len(reasons) > 0 {
+				return nil, fmt.Errorf("bad imagebuilder state: %s: %s", state, strings.Join(reasons, "; "))
+			}
+			return nil, fmt.Errorf("bad imagebuilder state: %s", state)
+		}
+
+		ui.Say(fmt.Sprintf("Waiting for ImageBuilder (%s) to become %s (state: %s, elapsed: %s)", name, want, state, elapsed))
+		wait := 5 * time.Second
+		elapsed += wait
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("timed out after %s waiting for ImageBuilder (%s) to become %s (state: %s)", timeout, name, want, state)
+		case <-time.After(wait):
+		}
+	}
+}
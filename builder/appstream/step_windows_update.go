@@ -0,0 +1,212 @@
+package appstream
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/retry"
+)
+
+const (
+	windowsUpdateScriptPath = `C:\Windows\Temp\packer-windows-update.ps1`
+	windowsUpdateLogPath    = `C:\Windows\Temp\packer-windows-update.log`
+	windowsUpdateTaskName   = "packer-windows-update"
+)
+
+// windowsUpdateScript installs every pending software update through the
+// Windows Update Agent API. It writes one `KB:<id>` line per installed update
+// and `REBOOT_REQUIRED` if a restart is needed to the log file.
+const windowsUpdateScript = `$ErrorActionPreference = 'Stop'
+$out = @()
+$session = New-Object -ComObject Microsoft.Update.Session
+$result = $session.CreateUpdateSearcher().Search("IsInstalled=0 and Type='Software' and IsHidden=0")
+$updates = New-Object -ComObject Microsoft.Update.UpdateColl
+foreach ($u in $result.Updates) {
+  if (-not $u.EulaAccepted) { $u.AcceptEula() }
+  [void]$updates.Add($u)
+}
+if ($updates.Count -gt 0) {
+  $downloader = $session.CreateUpdateDownloader()
+  $downloader.Updates = $updates
+  [void]$downloader.Download()
+  $installer = $session.CreateUpdateInstaller()
+  $installer.Updates = $updates
+  $install = $installer.Install()
+  for ($i = 0; $i -lt $updates.Count; $i++) {
+    if ($install.GetUpdateResult($i).ResultCode -eq 2) {
+      foreach ($kb in $updates.Item($i).KBArticleIDs) { $out += "KB:KB$kb" }
+    }
+  }
+  if ($install.RebootRequired) { $out += 'REBOOT_REQUIRED' }
+}
+$out += 'DONE'
+$out | Set-Content -Path '` + windowsUpdateLogPath + `'
+`
+
+// windowsUpdateRunner runs the update script as SYSTEM through a scheduled
+// task, since the Windows Update API refuses to install over a remote session.
+const windowsUpdateRunner = `$ErrorActionPreference = 'Stop'
+Remove-Item -Path '` + windowsUpdateLogPath + `' -ErrorAction SilentlyContinue
+$action = New-ScheduledTaskAction -Execute 'powershell.exe' -Argument '-NoProfile -ExecutionPolicy Bypass -File ` + windowsUpdateScriptPath + `'
+Register-ScheduledTask -TaskName '` + windowsUpdateTaskName + `' -Action $action -User 'SYSTEM' -RunLevel Highest -Force | Out-Null
+Start-ScheduledTask -TaskName '` + windowsUpdateTaskName + `'
+Start-Sleep -Seconds 5
+while ((Get-ScheduledTask -TaskName '` + windowsUpdateTaskName + `').State -eq 'Running') { Start-Sleep -Seconds 10 }
+Unregister-ScheduledTask -TaskName '` + windowsUpdateTaskName + `' -Confirm:$false
+Get-Content -Path '` + windowsUpdateLogPath + `'
+`
+
+// The number of update passes before giving up on reaching a fully patched state.
+const windowsUpdateMaxPasses = 5
+
+// StepWindowsUpdate installs Windows updates on the ImageBuilder, rebooting and
+// reconnecting until no more updates are pending.
+type StepWindowsUpdate struct {
+	config Config
+}
+
+var _ multistep.Step = new(StepWindowsUpdate)
+
+func (s *StepWindowsUpdate) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui, ok := state.Get("ui").(packersdk.Ui)
+	if !ok {
+		state.Put("error", fmt.Errorf("ui not found"))
+		return multistep.ActionHalt
+	}
+	comm, ok := state.Get("communicator").(packersdk.Communicator)
+	if !ok {
+		state.Put("error", fmt.Errorf("communicator not found"))
+		return multistep.ActionHalt
+	}
+
+	var installed []string
+	for pass := 1; pass <= windowsUpdateMaxPasses; pass++ {
+		ui.Say(fmt.Sprintf("Installing Windows updates (pass %d/%d)...", pass, windowsUpdateMaxPasses))
+
+		if err := comm.Upload(windowsUpdateScriptPath, strings.NewReader(windowsUpdateScript), nil); err != nil {
+			state.Put("error", fmt.Errorf("failed to upload Windows update script: %w", err))
+			return multistep.ActionHalt
+		}
+
+		var stdout bytes.Buffer
+		cmd := &packersdk.RemoteCmd{
+			Command: powershellCommand(windowsUpdateRunner),
+			Stdout:  &stdout,
+		}
+		if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+			state.Put("error", fmt.Errorf("failed to run Windows update: %w", err))
+			return multistep.ActionHalt
+		}
+		if cmd.ExitStatus() != 0 {
+			state.Put("error", fmt.Errorf("windows update failed with exit status: %d", cmd.ExitStatus()))
+			return multistep.ActionHalt
+		}
+
+		kbs, rebootRequired := parseWindowsUpdateOutput(stdout.String())
+		installed = append(installed, kbs...)
+		state.Put("installed_updates", installed)
+
+		if len(kbs) > 0 {
+			ui.Say(fmt.Sprintf("Installed updates: %s", strings.Join(kbs, ", ")))
+		}
+
+		if !rebootRequired {
+			if len(kbs) == 0 {
+				ui.Say("No more Windows updates to install")
+				return multistep.ActionContinue
+			}
+			continue
+		}
+
+		if err := restartWindows(ctx, comm, ui, s.config.RestartTimeout); err != nil {
+			state.Put("error", err)
+			return multistep.ActionHalt
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Stopped installing Windows updates after %d passes", windowsUpdateMaxPasses))
+	return multistep.ActionContinue
+}
+
+func (s *StepWindowsUpdate) Cleanup(multistep.StateBag) {
+	// Nothing to do
+}
+
+// parseWindowsUpdateOutput returns the installed KBs and whether a reboot is
+// required from the output of windowsUpdateScript.
+func parseWindowsUpdateOutput(output string) (kbs []string, rebootRequired bool) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "KB:"):
+			kbs = append(kbs, strings.TrimPrefix(line, "KB:"))
+		case line == "REBOOT_REQUIRED":
+			rebootRequired = true
+		}
+	}
+	return kbs, rebootRequired
+}
+
+// restartWindows reboots the ImageBuilder and waits for the communicator to
+// be able to run commands again.
+func restartWindows(ctx context.Context, comm packersdk.Communicator, ui packersdk.Ui, timeout time.Duration) error {
+	ui.Say("Restarting ImageBuilder...")
+
+	cmd := &packersdk.RemoteCmd{
+		Command: `shutdown /r /f /t 5 /c "Packer restart"`,
+	}
+	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+		return fmt.Errorf("failed to restart ImageBuilder: %w", err)
+	}
+	if cmd.ExitStatus() != 0 {
+		return fmt.Errorf("restart command failed with exit status: %d", cmd.ExitStatus())
+	}
+
+	// Give the machine a chance to actually go down before probing it
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(30 * time.Second):
+	}
+
+	err := retry.Config{
+		StartTimeout: timeout,
+		RetryDelay:   func() time.Duration { return 10 * time.Second },
+	}.Run(ctx, func(ctx context.Context) error {
+		probe := &packersdk.RemoteCmd{Command: "hostname"}
+		if err := comm.Start(ctx, probe); err != nil {
+			ui.Say("Waiting for ImageBuilder to come back up...")
+			return err
+		}
+		if status := probe.Wait(); status != 0 {
+			return fmt.Errorf("probe exited with status: %d", status)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("timed out waiting for ImageBuilder to restart: %w", err)
+	}
+
+	ui.Say("ImageBuilder restarted")
+	return nil
+}
+
+// powershellCommand wraps a PowerShell script into a single encoded command line.
+func powershellCommand(script string) string {
+	var buf bytes.Buffer
+	for _, r := range utf16.Encode([]rune(script)) {
+		_ = binary.Write(&buf, binary.LittleEndian, r)
+	}
+	return "powershell.exe -NoProfile -ExecutionPolicy Bypass -EncodedCommand " +
+		base64.StdEncoding.EncodeToString(buf.Bytes())
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
@@ -29,10 +30,12 @@ func LocateSingleAMI(ctx context.Context, id string, ec2Conn *ec2.Client) (*type
 	}
 }
 
-// IsImageSharedWith checks whether the given AMI (imageID) in the source account
-// is shared with the specified target accountID. It returns true if the AMI is
-// explicitly shared with the account, or if it is public.
-func IsImageSharedWith(ctx context.Context, image *types.Image, accountID string, ec2Conn *ec2.Client) (bool, error) {
+// IsImageSharedWith checks whether the given AMI in the source account is
+// shared with the grantee of the launch permission: an account, an
+// organization or an organizational unit. It returns true if the AMI is
+// explicitly shared with the grantee, if an organizational unit belongs to an
+// organization the AMI is shared with, or if the AMI is public.
+func IsImageSharedWith(ctx context.Context, image *types.Image, permission types.LaunchPermission, ec2Conn *ec2.Client) (bool, error) {
 	out, err := ec2Conn.DescribeImageAttribute(ctx, &ec2.DescribeImageAttributeInput{
 		ImageId:   image.ImageId,
 		Attribute: types.ImageAttributeNameLaunchPermission,
@@ -42,12 +45,9 @@ func IsImageSharedWith(ctx context.Context, image *types.Image, accountID string
 	}
 
 	// If no launch permissions are present, image is private to owner.
-	// Check each launch permission entry for a matching account ID or public group.
+	// Check each launch permission entry for a matching grantee or public group.
 	for _, lp := range out.LaunchPermissions {
-		if lp.UserId != nil && *lp.UserId == accountID {
-			return true, nil
-		}
-		if lp.Group != "" && lp.Group == "all" {
+		if launchPermissionCovers(lp, permission) {
 			return true, nil
 		}
 	}
@@ -55,45 +55,199 @@ func IsImageSharedWith(ctx context.Context, image *types.Image, accountID string
 	return false, nil
 }
 
-// EnsureImageSharedWith ensures the given AMI (imageID) in the source account
-// is shared with the target accountID.
-func EnsureImageSharedWith(ctx context.Context, image *types.Image, accountID *string, ec2Conn *ec2.Client) error {
-	if shared, err := IsImageSharedWith(ctx, image, *accountID, ec2Conn); err != nil {
-		return err
+// launchPermissionCovers reports whether the existing launch permission grants
+// everything the wanted one would.
+func launchPermissionCovers(existing, want types.LaunchPermission) bool {
+	switch {
+	case existing.Group == types.PermissionGroupAll:
+		return true
+	case want.UserId != nil:
+		return aws.ToString(existing.UserId) == *want.UserId
+	case want.OrganizationArn != nil:
+		return aws.ToString(existing.OrganizationArn) == *want.OrganizationArn
+	case want.OrganizationalUnitArn != nil:
+		if aws.ToString(existing.OrganizationalUnitArn) == *want.OrganizationalUnitArn {
+			return true
+		}
+		// An OU ARN embeds the ID of its organization:
+		// arn:aws:organizations::<account>:ou/<org-id>/<ou-id>
+		if existing.OrganizationArn != nil {
+			orgID := (*existing.OrganizationArn)[strings.LastIndex(*existing.OrganizationArn, "/")+1:]
+			return strings.Contains(*want.OrganizationalUnitArn, ":ou/"+orgID+"/")
+		}
+	}
+	return false
+}
+
+// ImageShare records the permissions added by EnsureImageSharedWith, so that
+// they can be removed again with RevokeImageShare. Permissions that existed
+// before are not recorded.
+type ImageShare struct {
+	ImageID    string
+	Permission types.LaunchPermission
+	// The snapshots whose create volume permission was added.
+	SnapshotIDs []string
+}
+
+// EnsureImageSharedWith ensures the given AMI in the source account is shared
+// with the grantee of the launch permission. Snapshots are only shared with
+// accounts, as organizations and OUs get access to them through the AMI. It
+// returns the permissions it added, or nil if the AMI was already shared.
+func EnsureImageSharedWith(ctx context.Context, image *types.Image, permission types.LaunchPermission, ec2Conn *ec2.Client) (*ImageShare, error) {
+	if shared, err := IsImageSharedWith(ctx, image, permission, ec2Conn); err != nil {
+		return nil, err
 	} else if shared {
-		return nil
+		return nil, nil
 	}
-	log.Println("Modifying LaunchPermissions for AMI", *image.ImageId, "with account", *accountID)
+	log.Println("Modifying LaunchPermissions for AMI", *image.ImageId, "with", LaunchPermissionGrantee(permission))
 	_, err := ec2Conn.ModifyImageAttribute(ctx, &ec2.ModifyImageAttributeInput{
 		ImageId: image.ImageId,
 		LaunchPermission: &types.LaunchPermissionModifications{
-			Add: []types.LaunchPermission{
-				{
-					UserId: accountID,
-				},
-			},
+			Add: []types.LaunchPermission{permission},
 		},
 	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	share := &ImageShare{ImageID: *image.ImageId, Permission: permission}
+
+	if permission.UserId == nil {
+		return share, nil
 	}
 
 	var errs *packer.MultiError
 	for _, bdm := range image.BlockDeviceMappings {
 		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
-			log.Printf("Modifying CreateVolumePermission for AMI %s with account %s", *image.ImageId, *accountID)
+			if shared, err := isSnapshotSharedWith(ctx, *bdm.Ebs.SnapshotId, *permission.UserId, ec2Conn); err != nil {
+				errs = packer.MultiErrorAppend(errs, err)
+				continue
+			} else if shared {
+				continue
+			}
+			log.Printf("Modifying CreateVolumePermission for AMI %s with account %s", *image.ImageId, *permission.UserId)
 			_, err := ec2Conn.ModifySnapshotAttribute(ctx, &ec2.ModifySnapshotAttributeInput{
 				SnapshotId: bdm.Ebs.SnapshotId,
 				CreateVolumePermission: &types.CreateVolumePermissionModifications{
 					Add: []types.CreateVolumePermission{
 						{
-							UserId: accountID,
+							UserId: permission.UserId,
 						},
 					},
 				},
 			})
 			if err != nil {
 				errs = packer.MultiErrorAppend(errs, err)
+				continue
+			}
+			share.SnapshotIDs = append(share.SnapshotIDs, *bdm.Ebs.SnapshotId)
+		}
+	}
+	if errs != nil && len(errs.Errors) != 0 {
+		return share, errs
+	}
+	return share, nil
+}
+
+// isSnapshotSharedWith checks whether the account may create volumes from the
+// snapshot, either explicitly or because the snapshot is public.
+func isSnapshotSharedWith(ctx context.Context, snapshotID, accountID string, ec2Conn *ec2.Client) (bool, error) {
+	out, err := ec2Conn.DescribeSnapshotAttribute(ctx, &ec2.DescribeSnapshotAttributeInput{
+		SnapshotId: aws.String(snapshotID),
+		Attribute:  types.SnapshotAttributeNameCreateVolumePermission,
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, permission := range out.CreateVolumePermissions {
+		if permission.Group == types.PermissionGroupAll || aws.ToString(permission.UserId) == accountID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RevokeImageShare removes the permissions recorded by EnsureImageSharedWith.
+func RevokeImageShare(ctx context.Context, share *ImageShare, ec2Conn *ec2.Client) error {
+	log.Println("Removing LaunchPermissions for AMI", share.ImageID, "from", LaunchPermissionGrantee(share.Permission))
+	if _, err := ec2Conn.ModifyImageAttribute(ctx, &ec2.ModifyImageAttributeInput{
+		ImageId: aws.String(share.ImageID),
+		LaunchPermission: &types.LaunchPermissionModifications{
+			Remove: []types.LaunchPermission{share.Permission},
+		},
+	}); err != nil {
+		return err
+	}
+
+	var errs *packer.MultiError
+	for _, snapshotID := range share.SnapshotIDs {
+		log.Printf("Removing CreateVolumePermission for snapshot %s from account %s", snapshotID, aws.ToString(share.Permission.UserId))
+		if _, err := ec2Conn.ModifySnapshotAttribute(ctx, &ec2.ModifySnapshotAttributeInput{
+			SnapshotId: aws.String(snapshotID),
+			CreateVolumePermission: &types.CreateVolumePermissionModifications{
+				Remove: []types.CreateVolumePermission{
+					{
+						UserId: share.Permission.UserId,
+					},
+				},
+			},
+		}); err != nil {
+			errs = packer.MultiErrorAppend(errs, err)
+		}
+	}
+	if errs != nil && len(errs.Errors) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// LaunchPermissionGrantee describes who a launch permission is granted to.
+func LaunchPermissionGrantee(permission types.LaunchPermission) string {
+	switch {
+	case permission.UserId != nil:
+		return "account " + *permission.UserId
+	case permission.OrganizationArn != nil:
+		return "organization " + *permission.OrganizationArn
+	case permission.OrganizationalUnitArn != nil:
+		return "organizational unit " + *permission.OrganizationalUnitArn
+	default:
+		return "group " + string(permission.Group)
+	}
+}
+
+// DeregistrationProtected reports whether the AMI has deregistration
+// protection, with or without a cooldown.
+func DeregistrationProtected(image *types.Image) bool {
+	return strings.HasPrefix(aws.ToString(image.DeregistrationProtection), "enabled")
+}
+
+// DestroyImage deregisters the given AMI and deletes the EBS snapshots backing it.
+// Deregistration protection is disabled first, an AMI protected with a
+// cooldown still cannot be deregistered until the cooldown ends.
+func DestroyImage(ctx context.Context, image *types.Image, ec2Conn *ec2.Client) error {
+	if DeregistrationProtected(image) {
+		log.Println("Disabling deregistration protection of AMI", *image.ImageId)
+		if _, err := ec2Conn.DisableImageDeregistrationProtection(ctx, &ec2.DisableImageDeregistrationProtectionInput{
+			ImageId: image.ImageId,
+		}); err != nil {
+			return err
+		}
+	}
+
+	log.Println("Deregistering AMI", *image.ImageId)
+	if _, err := ec2Conn.DeregisterImage(ctx, &ec2.DeregisterImageInput{
+		ImageId: image.ImageId,
+	}); err != nil {
+		return err
+	}
+
+	var errs *packer.MultiError
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+			log.Println("Deleting snapshot", *bdm.Ebs.SnapshotId, "of AMI", *image.ImageId)
+			if _, err := ec2Conn.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{
+				SnapshotId: bdm.Ebs.SnapshotId,
+			}); err != nil {
+				errs = packer.MultiErrorAppend(errs, err)
 			}
 		}
 	}
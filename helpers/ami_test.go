@@ -0,0 +1,144 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// newTestEC2Client returns an EC2 client talking to a local endpoint that
+// answers each action with the XML body returned by respond.
+func newTestEC2Client(t *testing.T, respond func(action string, r *http.Request) string) *ec2.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing request: %v", err)
+		}
+		action := r.Form.Get("Action")
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>test</requestId>%[2]s</%[1]sResponse>`, action, respond(action, r))
+	}))
+	t.Cleanup(server.Close)
+
+	return ec2.NewFromConfig(aws.Config{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+	}, func(o *ec2.Options) {
+		o.BaseEndpoint = aws.String(server.URL)
+	})
+}
+
+func TestEnsureImageSharedWith_Organizations(t *testing.T) {
+	const (
+		orgArn = "arn:aws:organizations::123456789012:organization/o-abc"
+		ouArn  = "arn:aws:organizations::123456789012:ou/o-abc/ou-def"
+	)
+
+	var added []string
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		switch action {
+		case "DescribeImageAttribute":
+			return `<imageId>ami-src</imageId><launchPermission><item><organizationArn>` + orgArn + `</organizationArn></item></launchPermission>`
+		case "ModifyImageAttribute":
+			for k, v := range r.Form {
+				if strings.HasPrefix(k, "LaunchPermission.Add.") {
+					added = append(added, v...)
+				}
+			}
+			return "<return>true</return>"
+		default:
+			t.Errorf("unexpected %s request", action)
+			return ""
+		}
+	})
+
+	image := &types.Image{
+		ImageId: aws.String("ami-src"),
+		BlockDeviceMappings: []types.BlockDeviceMapping{
+			{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-1")}},
+		},
+	}
+	for _, permission := range []types.LaunchPermission{
+		{OrganizationArn: aws.String(orgArn)},
+		{OrganizationalUnitArn: aws.String(ouArn)},
+		{OrganizationalUnitArn: aws.String("arn:aws:organizations::123456789012:ou/o-xyz/ou-ghi")},
+	} {
+		if _, err := EnsureImageSharedWith(context.Background(), image, permission, client); err != nil {
+			t.Fatalf("EnsureImageSharedWith failed: %v", err)
+		}
+	}
+
+	// The org and its OU are already covered, snapshots are not shared with OUs
+	if !slices.Equal(added, []string{"arn:aws:organizations::123456789012:ou/o-xyz/ou-ghi"}) {
+		t.Fatalf("expected only the OU of another organization to be added, got %v", added)
+	}
+}
+
+func TestEnsureImageSharedWith_RecordsAddedPermissions(t *testing.T) {
+	var modified []string
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		switch action {
+		case "DescribeImageAttribute":
+			return `<imageId>ami-src</imageId><launchPermission/>`
+		case "DescribeSnapshotAttribute":
+			if r.Form.Get("SnapshotId") == "snap-1" {
+				return `<snapshotId>snap-1</snapshotId><createVolumePermission><item><userId>111111111111</userId></item></createVolumePermission>`
+			}
+			return `<snapshotId>snap-2</snapshotId><createVolumePermission/>`
+		case "ModifyImageAttribute":
+			for k, v := range r.Form {
+				if strings.HasPrefix(k, "LaunchPermission.") {
+					modified = append(modified, action+" "+strings.Split(k, ".")[1]+" "+v[0])
+				}
+			}
+			return "<return>true</return>"
+		case "ModifySnapshotAttribute":
+			for k, v := range r.Form {
+				if strings.HasPrefix(k, "CreateVolumePermission.") {
+					modified = append(modified, action+" "+strings.Split(k, ".")[1]+" "+r.Form.Get("SnapshotId")+" "+v[0])
+				}
+			}
+			return "<return>true</return>"
+		default:
+			t.Errorf("unexpected %s request", action)
+			return ""
+		}
+	})
+
+	image := &types.Image{
+		ImageId: aws.String("ami-src"),
+		BlockDeviceMappings: []types.BlockDeviceMapping{
+			{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-1")}},
+			{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-2")}},
+		},
+	}
+	share, err := EnsureImageSharedWith(context.Background(), image, types.LaunchPermission{UserId: aws.String("111111111111")}, client)
+	if err != nil {
+		t.Fatalf("EnsureImageSharedWith failed: %v", err)
+	}
+	// snap-1 was already shared and must stay shared
+	if share == nil || !slices.Equal(share.SnapshotIDs, []string{"snap-2"}) {
+		t.Fatalf("unexpected share: %+v", share)
+	}
+
+	if err := RevokeImageShare(context.Background(), share, client); err != nil {
+		t.Fatalf("RevokeImageShare failed: %v", err)
+	}
+	if !slices.Equal(modified, []string{
+		"ModifyImageAttribute Add 111111111111",
+		"ModifySnapshotAttribute Add snap-2 111111111111",
+		"ModifyImageAttribute Remove 111111111111",
+		"ModifySnapshotAttribute Remove snap-2 111111111111",
+	}) {
+		t.Fatalf("unexpected modifications: %v", modified)
+	}
+}
@@ -0,0 +1,118 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
+)
+
+// AMI identifies an AMI in a region. AccountID is only known for AMIs listed
+// as `account:region:ami`, like the artifacts of the ami-copy post-processor.
+type AMI struct {
+	AccountID string
+	Region    string
+	ID        string
+}
+
+// AMIsFromArtifactID parses an artifact ID listing AMIs as `region:ami`, as
+// the Amazon builders do, or as `account:region:ami`, separated by commas.
+func AMIsFromArtifactID(artifactID string) ([]*AMI, error) {
+	var amis []*AMI
+	for entry := range strings.SplitSeq(artifactID, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		var ami *AMI
+		switch len(parts) {
+		case 2:
+			ami = &AMI{Region: parts[0], ID: parts[1]}
+		case 3:
+			ami = &AMI{AccountID: parts[0], Region: parts[1], ID: parts[2]}
+		}
+		if ami == nil || ami.Region == "" || !strings.HasPrefix(ami.ID, "ami-") {
+			return nil, fmt.Errorf("unable to parse %q as region:ami or account:region:ami", entry)
+		}
+		amis = append(amis, ami)
+	}
+	return amis, nil
+}
+
+// SourceAMIs returns the AMIs a post-processor works on: the given IDs and
+// the AMIs matching the filter when either is set, else those of the
+// artifact. IDs are either `ami` in the region of the config or `region:ami`.
+func SourceAMIs(ctx context.Context, artifact packer.Artifact, ids []string, filter *awscommon.AmiFilterOptions, cfg aws.Config) ([]*AMI, error) {
+	if len(ids) == 0 && filter.Empty() {
+		amis, err := AMIsFromArtifactID(artifact.Id())
+		if err != nil {
+			return nil, fmt.Errorf("unsupported artifact %s from %s: %w", artifact.Id(), artifact.BuilderId(), err)
+		}
+		return amis, nil
+	}
+
+	var amis []*AMI
+	for _, id := range ids {
+		if !strings.Contains(id, ":") {
+			id = cfg.Region + ":" + id
+		}
+		parsed, err := AMIsFromArtifactID(id)
+		if err != nil {
+			return nil, err
+		}
+		amis = append(amis, parsed...)
+	}
+
+	if !filter.Empty() {
+		images, err := FilteredAMIs(ctx, filter, ec2.NewFromConfig(cfg))
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range images {
+			amis = append(amis, &AMI{Region: cfg.Region, ID: aws.ToString(image.ImageId)})
+		}
+	}
+
+	return amis, nil
+}
+
+// FilteredAMIs returns the AMIs matching the filter options: only the newest
+// one with `most_recent`, otherwise all of them.
+func FilteredAMIs(ctx context.Context, filter *awscommon.AmiFilterOptions, ec2Conn *ec2.Client) ([]types.Image, error) {
+	input := &ec2.DescribeImagesInput{
+		Owners:            filter.Owners,
+		IncludeDeprecated: aws.Bool(filter.IncludeDeprecated),
+	}
+	for name, value := range filter.Filters {
+		input.Filters = append(input.Filters, types.Filter{
+			Name:   aws.String(name),
+			Values: []string{value},
+		})
+	}
+
+	var images []types.Image
+	paginator := ec2.NewDescribeImagesPaginator(ec2Conn, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, page.Images...)
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no AMI matched the source_ami_filter")
+	}
+
+	if filter.MostRecent {
+		sort.Slice(images, func(i, j int) bool {
+			return aws.ToString(images[i].CreationDate) > aws.ToString(images[j].CreationDate)
+		})
+		images = images[:1]
+	}
+
+	return images, nil
+}
@@ -0,0 +1,87 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func TestAMIsFromArtifactID(t *testing.T) {
+	artifact := "us-east-1:ami-123,us-west-2:ami-456"
+	amis, err := AMIsFromArtifactID(artifact)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(amis) != 2 {
+		t.Fatalf("expected 2 amis, got %d", len(amis))
+	}
+
+	if amis[0].Region != "us-east-1" || amis[0].ID != "ami-123" {
+		t.Fatalf("first ami mismatch: %+v", amis[0])
+	}
+
+	if amis[1].Region != "us-west-2" || amis[1].ID != "ami-456" {
+		t.Fatalf("second ami mismatch: %+v", amis[1])
+	}
+
+	// As listed by the artifacts of ami-copy
+	amis, err = AMIsFromArtifactID("111111111111:eu-west-1:ami-789")
+	if err != nil || len(amis) != 1 || amis[0].AccountID != "111111111111" || amis[0].Region != "eu-west-1" || amis[0].ID != "ami-789" {
+		t.Fatalf("account ami mismatch: %+v (%v)", amis, err)
+	}
+
+	for _, id := range []string{"", "ami-123", "us-east-1:i-123", "a:b:c:ami-1", "my-image.vmdk"} {
+		if _, err := AMIsFromArtifactID(id); err == nil {
+			t.Fatalf("expected %q not to parse", id)
+		}
+	}
+}
+
+func TestSourceAMIs(t *testing.T) {
+	artifact := &packersdk.MockArtifact{
+		BuilderIdValue: "packer.post-processor.ami-copy",
+		IdValue:        "111111111111:us-east-1:ami-1",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("Owner.1") != "self" || r.Form.Get("Filter.1.Name") != "name" {
+			t.Errorf("unexpected request: %v", r.Form)
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, `<DescribeImagesResponse><imagesSet>
+			<item><imageId>ami-old</imageId><creationDate>2024-01-01T00:00:00.000Z</creationDate></item>
+			<item><imageId>ami-new</imageId><creationDate>2024-06-01T00:00:00.000Z</creationDate></item>
+		</imagesSet></DescribeImagesResponse>`)
+	}))
+	defer server.Close()
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}, BaseEndpoint: aws.String(server.URL)}
+
+	amis, err := SourceAMIs(context.Background(), artifact, nil, &awscommon.AmiFilterOptions{}, cfg)
+	if err != nil || len(amis) != 1 || amis[0].ID != "ami-1" || amis[0].AccountID != "111111111111" {
+		t.Fatalf("expected the AMIs of the artifact, got %+v (%v)", amis, err)
+	}
+
+	filter := &awscommon.AmiFilterOptions{
+		Owners:     []string{"self"},
+		Filters:    map[string]string{"name": "golden-*"},
+		MostRecent: true,
+	}
+	amis, err = SourceAMIs(context.Background(), artifact, []string{"ami-2", "us-west-2:ami-3"}, filter, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, ami := range amis {
+		got = append(got, ami.Region+":"+ami.ID)
+	}
+	if !slices.Equal(got, []string{"eu-west-1:ami-2", "us-west-2:ami-3", "eu-west-1:ami-new"}) {
+		t.Fatalf("unexpected source AMIs: %v", got)
+	}
+}
@@ -0,0 +1,153 @@
+package ami_copy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	registryimage "github.com/hashicorp/packer-plugin-sdk/packer/registry/image"
+
+	"github.com/bdwyertech/packer-plugin-aws/helpers"
+)
+
+// CopiedImage is an AMI copied into a target account.
+type CopiedImage struct {
+	AccountID     string
+	Region        string
+	ImageID       string
+	SourceImageID string
+
+	// Whether the image was created by this post-processor, as opposed to a
+	// source image that was only re-tagged, and may therefore be destroyed.
+	owned bool
+	// Credentials of the target account.
+	config aws.Config
+	// The snapshots fast snapshot restore was enabled for, by zone.
+	fastRestores map[string][]string
+}
+
+// Artifact is an artifact implementation that contains the copied AMIs.
+type Artifact struct {
+	Images []*CopiedImage
+
+	// BuilderId is the unique ID for the post-processor that created these AMIs
+	BuilderIdValue string
+
+	// StateData should store data such as GeneratedData
+	// to be shared with post-processors
+	StateData map[string]any
+}
+
+var _ packer.Artifact = new(Artifact)
+
+func (a *Artifact) BuilderId() string {
+	return a.BuilderIdValue
+}
+
+func (*Artifact) Files() []string {
+	// We have no files
+	return nil
+}
+
+// Id returns every copied AMI as `account:region:ami`, separated by commas.
+func (a *Artifact) Id() string {
+	parts := make([]string, 0, len(a.Images))
+	for _, image := range a.Images {
+		parts = append(parts, fmt.Sprintf("%s:%s:%s", image.AccountID, image.Region, image.ImageID))
+	}
+
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (a *Artifact) String() string {
+	amiStrings := make([]string, 0, len(a.Images))
+	for _, image := range a.Images {
+		amiStrings = append(amiStrings, fmt.Sprintf("%s (%s): %s", image.AccountID, image.Region, image.ImageID))
+	}
+
+	sort.Strings(amiStrings)
+	return fmt.Sprintf("AMIs were copied:\n%s\n", strings.Join(amiStrings, "\n"))
+}
+
+func (a *Artifact) State(name string) any {
+	if data, ok := a.StateData[name]; ok {
+		return data
+	}
+
+	switch name {
+	// To be able to push metadata to HCP Packer Registry, Packer will read the 'par.artifact.metadata'
+	// state from artifacts to get a build's metadata.
+	case registryimage.ArtifactStateURI:
+		return a.stateHCPPackerRegistryMetadata()
+	default:
+		return nil
+	}
+}
+
+// Destroy disables the fast snapshot restores enabled for the copied AMIs,
+// then deregisters them and deletes their snapshots, with the credentials of
+// the account each of them was copied into.
+func (a *Artifact) Destroy() error {
+	ctx := context.TODO()
+
+	var errs *packer.MultiError
+	for _, image := range a.Images {
+		if len(image.fastRestores) == 0 && !image.owned {
+			continue
+		}
+
+		cfg := image.config.Copy()
+		cfg.Region = image.Region
+		client := ec2.NewFromConfig(cfg)
+
+		if len(image.fastRestores) > 0 {
+			log.Printf("Disabling fast snapshot restore of image ID (%s) in account %s", image.ImageID, image.AccountID)
+			if err := disableFastRestores(ctx, client, image.fastRestores); err != nil {
+				errs = packer.MultiErrorAppend(errs, err)
+			}
+		}
+		if !image.owned {
+			continue
+		}
+
+		log.Printf("Deregistering image ID (%s) from account %s in region (%s)", image.ImageID, image.AccountID, image.Region)
+
+		img, err := helpers.LocateSingleAMI(ctx, image.ImageID, client)
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, err)
+			continue
+		}
+		if err := helpers.DestroyImage(ctx, img, client); err != nil {
+			errs = packer.MultiErrorAppend(errs, err)
+		}
+	}
+
+	if errs != nil && len(errs.Errors) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// stateHCPPackerRegistryMetadata returns an HCP Packer registry image for each
+// of the copied AMIs, labelled with the account it was copied into.
+func (a *Artifact) stateHCPPackerRegistryMetadata() any {
+	images := make([]*registryimage.Image, 0, len(a.Images))
+	for _, image := range a.Images {
+		images = append(images, &registryimage.Image{
+			ImageID:        image.ImageID,
+			ProviderName:   "aws",
+			ProviderRegion: image.Region,
+			SourceImageID:  image.SourceImageID,
+			Labels: map[string]string{
+				"account_id": image.AccountID,
+			},
+		})
+	}
+	return images
+}
@@ -2,7 +2,10 @@ package ami_copy
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -11,89 +14,286 @@ import (
 	"github.com/sourcegraph/conc/pool"
 
 	"github.com/bdwyertech/packer-plugin-aws/helpers"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
 	"github.com/hashicorp/packer-plugin-amazon/builder/common/awserrors"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 	"github.com/hashicorp/packer-plugin-sdk/retry"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
 )
 
-// AmiManifest holds the data about the resulting copied image
-type AmiManifest struct {
-	AccountID string `json:"account_id"`
-	Region    string `json:"region"`
-	ImageID   string `json:"image_id"`
-}
+// SourceAMITag is the tag ami-copy puts on every copy, holding the ID of the
+// AMI it was copied from.
+const SourceAMITag = "ami-copy:source-ami"
 
 // copyOperation holds data and methods related to copying an image.
 type copyOperation struct {
 	ctx             context.Context
 	client          *ec2.Client
+	sourceConfig    aws.Config
+	targetConfig    aws.Config
 	sourceImage     *types.Image
 	sourceRegion    string
 	sourceImageID   string
+	targetRegion    string
 	copiedImageID   string
+	copiedImage     *types.Image
+	reused          bool
 	ensureAvailable bool
 	tagsOnly        bool
 	tags            map[string]string
+	snapshotTags    map[string]string
+	nameTemplate    string
+	descTemplate    string
+	tmplCtx         interpolate.Context
+	name            string
 	encrypted       bool
 	kmsKeyID        string
 	targetAccountID string
+	startTime       time.Time
+	endTime         time.Time
+	copyTimeout     time.Duration
+	pollInterval    time.Duration
+
+	deprecateAt              string
+	deregistrationProtection awscommon.DeregistrationProtectionOptions
+	requireBlockPublicAccess bool
+	ssmParameter             *SSMParameterConfig
+	publishedParameter       string
+	fastLaunch               bool
+	fastRestoreAZs           []string
+	// The zones fast snapshot restore is enabled in, and the snapshots it was
+	// enabled for by this run, by zone.
+	fastRestoredAZs []string
+	fastRestores    map[string][]string
+	createKMSGrants bool
+	sourceKMSKeys   []string
+	kmsGrants       []kmsGrant
+	// How the source is shared with the target account, for the dry run plan,
+	// and whether it would only be shared by the run.
+	planShare    string
+	planUnshared bool
+	// Shares of the source to revoke once no copy depends on them.
+	shares []*sourceShare
+	// The progress of the copies, and how this one was picked up from it.
+	state        *copyState
+	resumeStatus string
 }
 
 // execute performs the EC2 copy and tags the result.
 func (c *copyOperation) execute(ui packer.Ui) error {
-	var name, description string
-	if c.sourceImage.Name != nil {
-		name = *c.sourceImage.Name
+	name, err := c.render(c.nameTemplate, aws.ToString(c.sourceImage.Name))
+	if err != nil {
+		return fmt.Errorf("unable to render AMI name: %w", err)
 	}
-	if c.sourceImage.Description != nil {
-		description = *c.sourceImage.Description
+	description, err := c.render(c.descTemplate, aws.ToString(c.sourceImage.Description))
+	if err != nil {
+		return fmt.Errorf("unable to render AMI description: %w", err)
 	}
+	c.name = name
 
-	if !c.tagsOnly {
-		// Perform the copy
-		input := &ec2.CopyImageInput{
-			Name:          aws.String(name),
-			Description:   aws.String(description),
-			SourceImageId: aws.String(c.sourceImageID),
-			SourceRegion:  aws.String(c.sourceRegion),
-			Encrypted:     aws.Bool(c.encrypted),
+	tags, err := c.imageTags()
+	if err != nil {
+		return err
+	}
+	snapshotTags, err := c.renderTags(c.snapshotTags)
+	if err != nil {
+		return err
+	}
+
+	// Pick up the copy recorded by an earlier run
+	if !c.tagsOnly && c.state != nil && c.resume(ui) {
+		return nil
+	}
+
+	if !c.tagsOnly && c.requireBlockPublicAccess {
+		if err := c.checkBlockPublicAccess(); err != nil {
+			return err
 		}
+	}
 
-		if c.kmsKeyID != "" {
-			input.KmsKeyId = aws.String(c.kmsKeyID)
+	if !c.tagsOnly && !c.reused {
+		// Reuse a copy made by an earlier run
+		existing, err := c.findExistingCopy(name)
+		if err != nil {
+			return err
 		}
+		if existing != nil {
+			ui.Say(fmt.Sprintf("Reusing existing copy %s of %s in account %s", *existing.ImageId, c.sourceImageID, c.targetAccountID))
+			c.copiedImageID = *existing.ImageId
+			c.copiedImage = existing
+			c.reused = true
+		}
+	}
 
+	if !c.tagsOnly && !c.reused {
+		// Make sure the target account can use the keys of the source, the
+		// grants are revoked once the copy is available
+		if err := c.prepareKMS(ui); err != nil {
+			return err
+		}
+		defer c.revokeKMSGrants(ui)
+
+		// Perform the copy
+		input := c.copyImageInput(name, description, tags, snapshotTags)
 		output, err := c.client.CopyImage(c.ctx, input)
 		if err != nil {
-			return err
+			return c.kmsError(err)
 		}
 		c.copiedImageID = *output.ImageId
+
+		m := c.manifest(nil)
+		m.Status = StatusInProgress
+		c.recordState(ui, m)
 	} else {
-		ui.Say(fmt.Sprintf("Only copying tags in %s as tags_only=true", c.targetAccountID))
-		c.copiedImageID = c.sourceImageID
-	}
+		if c.tagsOnly {
+			ui.Say(fmt.Sprintf("Only copying tags in %s as tags_only=true", c.targetAccountID))
+			c.copiedImageID = c.sourceImageID
+		}
 
-	// Tag the copied image
-	if err := c.tagImage(ui); err != nil {
-		return err
+		// Tag the existing image, and the snapshots of a reused copy
+		if err := c.createTags(ui, []string{c.copiedImageID}, tags); err != nil {
+			return err
+		}
+		if c.reused && len(snapshotTags) > 0 {
+			if err := c.createTags(ui, imageSnapshotIDs(c.copiedImage), snapshotTags); err != nil {
+				return err
+			}
+		}
 	}
 
-	// Wait for image to be available if requested
-	if c.ensureAvailable {
+	// Wait for image to be available if requested, or to apply settings that
+	// need an available image
+	applySettings := !c.tagsOnly && (c.deprecateAt != "" || c.deregistrationProtection.Enabled)
+	imageParameter := c.ssmParameter != nil && c.ssmParameter.needsAvailableImage()
+	if c.ensureAvailable || applySettings || c.fastRestoreEnabled() || len(c.kmsGrants) > 0 || len(c.shares) > 0 || imageParameter {
 		if err := c.waitForAvailable(ui); err != nil {
 			return err
 		}
 	}
 
+	if applySettings {
+		if err := c.applyImageSettings(ui); err != nil {
+			return err
+		}
+	}
+
+	if c.fastRestoreEnabled() {
+		if err := c.enableFastRestore(ui); err != nil {
+			return err
+		}
+	}
+
+	if c.ssmParameter != nil && c.ssmParameter.enabled() {
+		if err := c.publishParameter(ui); err != nil {
+			return err
+		}
+	}
+
+	// Record the copy as it stands for the manifest
+	if c.tagsOnly {
+		c.copiedImage = c.sourceImage
+	} else if image, err := helpers.LocateSingleAMI(c.ctx, c.copiedImageID, c.client); err == nil {
+		c.copiedImage = image
+	}
+
 	return nil
 }
 
-// tagImage copies tags from the source image to the target.
-func (c *copyOperation) tagImage(ui packer.Ui) error {
-	tags := make([]types.Tag, 0, len(c.sourceImage.Tags)+len(c.tags))
+// copyImageInput returns the input copying the source image, tagging the copy
+// and its snapshots as they are created.
+func (c *copyOperation) copyImageInput(name, description string, tags, snapshotTags []types.Tag) *ec2.CopyImageInput {
+	input := &ec2.CopyImageInput{
+		Name:          aws.String(name),
+		Description:   aws.String(description),
+		SourceImageId: aws.String(c.sourceImageID),
+		SourceRegion:  aws.String(c.sourceRegion),
+		Encrypted:     aws.Bool(c.encrypted),
+	}
+
+	if c.kmsKeyID != "" {
+		input.KmsKeyId = aws.String(c.kmsKeyID)
+	}
 
-	// Copy source tags
+	input.TagSpecifications = append(input.TagSpecifications, types.TagSpecification{
+		ResourceType: types.ResourceTypeImage,
+		Tags:         tags,
+	})
+	if len(snapshotTags) > 0 {
+		input.TagSpecifications = append(input.TagSpecifications, types.TagSpecification{
+			ResourceType: types.ResourceTypeSnapshot,
+			Tags:         snapshotTags,
+		})
+	}
+
+	return input
+}
+
+// templateData is the data available to the templates rendered for each copy:
+// `{{ .TargetAccountID }}`, `{{ .Region }}`, `{{ .SourceAMI }}`,
+// `{{ .SourceAMIName }}`, `{{ .SourceAMITags }}` and `{{ .ImageID }}`, the
+// ID of the copy once known.
+type templateData struct {
+	TargetAccountID string
+	Region          string
+	SourceAMI       string
+	SourceAMIName   string
+	SourceAMITags   map[string]string
+	ImageID         string
+}
+
+func (c *copyOperation) templateData() *templateData {
+	tags := make(map[string]string, len(c.sourceImage.Tags))
 	for _, tag := range c.sourceImage.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	return &templateData{
+		TargetAccountID: c.targetAccountID,
+		Region:          c.targetRegion,
+		SourceAMI:       c.sourceImageID,
+		SourceAMIName:   aws.ToString(c.sourceImage.Name),
+		SourceAMITags:   tags,
+		ImageID:         c.copiedImageID,
+	}
+}
+
+// render renders a template of the copy with the configuration context, or
+// returns the fallback when the template is empty.
+func (c *copyOperation) render(tmpl, fallback string) (string, error) {
+	if tmpl == "" {
+		return fallback, nil
+	}
+	ctx := c.tmplCtx
+	ctx.Data = c.templateData()
+	return interpolate.Render(tmpl, &ctx)
+}
+
+// renderTags renders the values of the tags.
+func (c *copyOperation) renderTags(tags map[string]string) ([]types.Tag, error) {
+	rendered := make([]types.Tag, 0, len(tags))
+	for k, v := range tags {
+		value, err := c.render(v, "")
+		if err != nil {
+			return nil, fmt.Errorf("unable to render tag %s: %w", k, err)
+		}
+		rendered = append(rendered, types.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(value),
+		})
+	}
+	return rendered, nil
+}
+
+// imageTags returns the tags of the copy: the source tags, the additional
+// tags and the source AMI tag.
+func (c *copyOperation) imageTags() ([]types.Tag, error) {
+	tags := make([]types.Tag, 0, len(c.sourceImage.Tags)+len(c.tags)+1)
+
+	// Copy source tags, unless overridden
+	for _, tag := range c.sourceImage.Tags {
+		if _, ok := c.tags[aws.ToString(tag.Key)]; ok {
+			continue
+		}
 		tags = append(tags, types.Tag{
 			Key:   tag.Key,
 			Value: tag.Value,
@@ -101,14 +301,26 @@ func (c *copyOperation) tagImage(ui packer.Ui) error {
 	}
 
 	// Add additional tags
-	for k, v := range c.tags {
+	additional, err := c.renderTags(c.tags)
+	if err != nil {
+		return nil, err
+	}
+	tags = append(tags, additional...)
+
+	// Record the source so that later runs can find this copy
+	if !c.tagsOnly {
 		tags = append(tags, types.Tag{
-			Key:   aws.String(k),
-			Value: aws.String(v),
+			Key:   aws.String(SourceAMITag),
+			Value: aws.String(c.sourceImageID),
 		})
 	}
 
-	if len(tags) == 0 {
+	return tags, nil
+}
+
+// createTags tags existing resources in the target account.
+func (c *copyOperation) createTags(ui packer.Ui, resources []string, tags []types.Tag) error {
+	if len(resources) == 0 || len(tags) == 0 {
 		return nil
 	}
 
@@ -123,7 +335,7 @@ func (c *copyOperation) tagImage(ui packer.Ui) error {
 		RetryDelay: (&retry.Backoff{InitialBackoff: 200 * time.Millisecond, MaxBackoff: 30 * time.Second, Multiplier: 2}).Linear,
 	}.Run(c.ctx, func(ctx context.Context) error {
 		_, err := c.client.CreateTags(ctx, &ec2.CreateTagsInput{
-			Resources: []string{c.copiedImageID},
+			Resources: resources,
 			Tags:      tags,
 		})
 
@@ -136,39 +348,192 @@ func (c *copyOperation) tagImage(ui packer.Ui) error {
 	})
 }
 
-// waitForAvailable waits for the copied image to become available.
-func (c *copyOperation) waitForAvailable(ui packer.Ui) error {
-	ui.Say("Going to wait for image to be in available state")
+// imageSnapshotIDs returns the EBS snapshots backing the image.
+func imageSnapshotIDs(image *types.Image) (ids []string) {
+	if image == nil {
+		return nil
+	}
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+			ids = append(ids, *bdm.Ebs.SnapshotId)
+		}
+	}
+	return ids
+}
 
-	for i := 1; i <= 30; i++ {
-		image, err := helpers.LocateSingleAMI(c.ctx, c.copiedImageID, c.client)
-		if err != nil && image == nil {
+// checkBlockPublicAccess fails unless block public access for AMIs is enabled
+// in the target account and region.
+func (c *copyOperation) checkBlockPublicAccess() error {
+	output, err := c.client.GetImageBlockPublicAccessState(c.ctx, &ec2.GetImageBlockPublicAccessStateInput{})
+	if err != nil {
+		return fmt.Errorf("unable to get block public access state of account %s in %s: %w", c.targetAccountID, c.targetRegion, err)
+	}
+	if state := aws.ToString(output.ImageBlockPublicAccessState); state != string(types.ImageBlockPublicAccessEnabledStateBlockNewSharing) {
+		return fmt.Errorf("refusing to copy %s: block public access for AMIs is %s in account %s in %s", c.sourceImageID, state, c.targetAccountID, c.targetRegion)
+	}
+	return nil
+}
+
+// applyImageSettings deprecates the copy and protects it from deregistration.
+func (c *copyOperation) applyImageSettings(ui packer.Ui) error {
+	if c.deprecateAt != "" {
+		deprecateAt, err := time.Parse(time.RFC3339, c.deprecateAt)
+		if err != nil {
 			return err
 		}
+		ui.Say(fmt.Sprintf("Deprecating %s in account %s at %s", c.copiedImageID, c.targetAccountID, c.deprecateAt))
+		if _, err := c.client.EnableImageDeprecation(c.ctx, &ec2.EnableImageDeprecationInput{
+			ImageId:     aws.String(c.copiedImageID),
+			DeprecateAt: aws.Time(deprecateAt),
+		}); err != nil {
+			return fmt.Errorf("unable to deprecate %s: %w", c.copiedImageID, err)
+		}
+	}
 
-		switch image.State {
-		case types.ImageStateAvailable:
-			return nil
-		case types.ImageStateFailed:
-			return fmt.Errorf("AMI copy failed: image %s transitioned to failed state on account %s", *image.ImageId, c.targetAccountID)
+	if c.deregistrationProtection.Enabled {
+		ui.Say(fmt.Sprintf("Enabling deregistration protection on %s in account %s (cooldown: %t)",
+			c.copiedImageID, c.targetAccountID, c.deregistrationProtection.WithCooldown))
+		if _, err := c.client.EnableImageDeregistrationProtection(c.ctx, &ec2.EnableImageDeregistrationProtectionInput{
+			ImageId:      aws.String(c.copiedImageID),
+			WithCooldown: aws.Bool(c.deregistrationProtection.WithCooldown),
+		}); err != nil {
+			return fmt.Errorf("unable to enable deregistration protection on %s: %w", c.copiedImageID, err)
 		}
+	}
+
+	return nil
+}
 
-		ui.Say(fmt.Sprintf("Waiting one minute (%d/30) for AMI to become available, current state: %s for image %s on account %s",
-			i, image.State, *image.ImageId, c.targetAccountID))
-		time.Sleep(time.Duration(1) * time.Minute)
+// findExistingCopy looks for a copy of the source image made by an earlier run
+// in the target account and region, matched by the source tag or by name.
+// Images matched by name whose source tag or source image is another AMI are
+// not copies of the source.
+func (c *copyOperation) findExistingCopy(name string) (*types.Image, error) {
+	filters := [][]types.Filter{
+		{{Name: aws.String("tag:" + SourceAMITag), Values: []string{c.sourceImageID}}},
+	}
+	if name != "" {
+		filters = append(filters, []types.Filter{{Name: aws.String("name"), Values: []string{name}}})
+	}
+
+	for _, f := range filters {
+		output, err := c.client.DescribeImages(c.ctx, &ec2.DescribeImagesInput{
+			Owners:  []string{"self"},
+			Filters: f,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range output.Images {
+			// The source itself when copying within its own account and region
+			if aws.ToString(image.ImageId) == c.sourceImageID || c.copiedFromOtherSource(&image) {
+				continue
+			}
+			switch image.State {
+			case types.ImageStateAvailable, types.ImageStatePending:
+				return &image, nil
+			}
+		}
 	}
 
-	return fmt.Errorf("Timed out waiting for image %s to copy to account %s", c.copiedImageID, c.targetAccountID)
+	return nil, nil
 }
 
-// executeCopies runs all copy operations concurrently.
-func (p PostProcessor) executeCopies(copies []*copyOperation, ui packer.Ui) (errs packer.MultiError) {
-	copyCount := len(copies)
-	amiManifests := make(chan *AmiManifest, copyCount)
+// copiedFromOtherSource reports whether the image is tagged as, or was copied
+// from, an AMI other than the source.
+func (c *copyOperation) copiedFromOtherSource(image *types.Image) bool {
+	if id := aws.ToString(image.SourceImageId); id != "" && id != c.sourceImageID {
+		return true
+	}
+	for _, tag := range image.Tags {
+		if aws.ToString(tag.Key) == SourceAMITag && aws.ToString(tag.Value) != c.sourceImageID {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForAvailable waits for the copied image to become available, reporting
+// the progress of its snapshots. It gives up after the copy timeout or when
+// the context is cancelled.
+func (c *copyOperation) waitForAvailable(ui packer.Ui) error {
+	ui.Say(fmt.Sprintf("Waiting up to %s for image %s to be available on account %s", c.copyTimeout, c.copiedImageID, c.targetAccountID))
+
+	waiter := ec2.NewImageAvailableWaiter(c.client, func(o *ec2.ImageAvailableWaiterOptions) {
+		o.MinDelay = c.pollInterval
+		o.MaxDelay = c.pollInterval
+		retryable := o.Retryable
+		o.Retryable = func(ctx context.Context, input *ec2.DescribeImagesInput, output *ec2.DescribeImagesOutput, err error) (bool, error) {
+			if err == nil && len(output.Images) > 0 {
+				image := output.Images[0]
+				switch image.State {
+				case types.ImageStateAvailable:
+					return false, nil
+				case types.ImageStateFailed:
+					reason := ""
+					if image.StateReason != nil {
+						reason = ": " + aws.ToString(image.StateReason.Message)
+					}
+					return false, c.kmsError(fmt.Errorf("AMI copy failed: image %s transitioned to failed state on account %s%s", c.copiedImageID, c.targetAccountID, reason))
+				}
+				c.reportProgress(ctx, ui, &image)
+			}
+			return retryable(ctx, input, output, err)
+		}
+	})
+
+	// The deadline is that of the context, the waiter is given more time so
+	// that it never gives up first
+	ctx, cancel := context.WithTimeout(c.ctx, c.copyTimeout)
+	defer cancel()
+	err := waiter.Wait(ctx, &ec2.DescribeImagesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("image-id"),
+				Values: []string{c.copiedImageID},
+			},
+		},
+	}, 2*c.copyTimeout)
+	switch {
+	case err == nil:
+		return nil
+	case c.ctx.Err() != nil:
+		return fmt.Errorf("cancelled waiting for image %s to copy to account %s: %w", c.copiedImageID, c.targetAccountID, c.ctx.Err())
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("timed out after %s waiting for image %s to copy to account %s", c.copyTimeout, c.copiedImageID, c.targetAccountID)
+	default:
+		return err
+	}
+}
+
+// reportProgress reports the state of the image and the progress of its snapshots.
+func (c *copyOperation) reportProgress(ctx context.Context, ui packer.Ui, image *types.Image) {
+	progress := []string{}
+	if ids := imageSnapshotIDs(image); len(ids) > 0 {
+		output, err := c.client.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{SnapshotIds: ids})
+		if err == nil {
+			for _, snapshot := range output.Snapshots {
+				progress = append(progress, fmt.Sprintf("%s %s", aws.ToString(snapshot.SnapshotId), aws.ToString(snapshot.Progress)))
+			}
+		}
+	}
+
+	msg := fmt.Sprintf("Image %s on account %s in %s is %s", c.copiedImageID, c.targetAccountID, c.targetRegion, image.State)
+	if len(progress) > 0 {
+		msg += fmt.Sprintf(" (snapshots: %s)", strings.Join(progress, ", "))
+	}
+	ui.Say(msg)
+}
+
+// executeCopies runs all copy operations concurrently. It returns a manifest
+// entry for every operation, failed ones included, and notifies n of each
+// finished operation when set.
+func (p PostProcessor) executeCopies(copies []*copyOperation, ui packer.Ui, n *notifier) (manifests []*AmiManifest, errs packer.MultiError) {
+	var mu sync.Mutex
 
 	concurrencyCount := p.config.CopyConcurrency
 	if concurrencyCount == 0 { // Unlimited
-		concurrencyCount = copyCount
+		concurrencyCount = len(copies)
 	}
 
 	pool := pool.New().WithMaxGoroutines(concurrencyCount)
@@ -177,33 +542,45 @@ func (p PostProcessor) executeCopies(copies []*copyOperation, ui packer.Ui) (err
 		pool.Go(func() {
 			ui.Say(
 				fmt.Sprintf(
-					"[%s] Copying %s to account %s (encrypted: %t)",
+					"[%s] Copying %s to account %s in %s (encrypted: %t)",
 					copy.sourceRegion,
 					copy.sourceImageID,
 					copy.targetAccountID,
+					copy.targetRegion,
 					copy.encrypted,
 				),
 			)
 
-			if err := copy.execute(ui); err != nil {
-				ui.Error(err.Error())
+			copy.startTime = time.Now().UTC()
+			err := copy.execute(ui)
+			copy.endTime = time.Now().UTC()
+			releaseShares(copy.ctx, ui, copy.shares)
+
+			m := copy.manifest(err)
+			copy.recordState(ui, m)
+			mu.Lock()
+			manifests = append(manifests, m)
+			if err != nil {
 				packer.MultiErrorAppend(&errs, err)
-				return
 			}
+			mu.Unlock()
 
-			manifest := &AmiManifest{
-				AccountID: copy.targetAccountID,
-				Region:    copy.sourceRegion,
-				ImageID:   copy.copiedImageID,
+			if n != nil {
+				n.notifyCopy(copy.ctx, ui, copy, m)
+			}
+
+			if err != nil {
+				ui.Error(err.Error())
+				return
 			}
-			amiManifests <- manifest
 
 			ui.Say(
 				fmt.Sprintf(
-					"[%s] Finished copying %s to %s (copied id: %s)",
+					"[%s] Finished copying %s to %s in %s (copied id: %s)",
 					copy.sourceRegion,
 					copy.sourceImageID,
 					copy.targetAccountID,
+					copy.targetRegion,
 					copy.copiedImageID,
 				),
 			)
@@ -211,23 +588,41 @@ func (p PostProcessor) executeCopies(copies []*copyOperation, ui packer.Ui) (err
 	}
 	pool.Wait()
 
-	if p.config.ManifestOutput != "" {
-		manifests := []*AmiManifest{}
-	LOOP:
-		for {
-			select {
-			case m := <-amiManifests:
-				manifests = append(manifests, m)
-			default:
-				break LOOP
-			}
-		}
-		err := writeManifests(p.config.ManifestOutput, manifests)
-		if err != nil {
-			ui.Say(fmt.Sprintf("Unable to write out manifest to %s: %s", p.config.ManifestOutput, err))
-		}
+	return manifests, errs
+}
+
+// manifest returns the manifest entry describing the outcome of the copy.
+func (c *copyOperation) manifest(err error) *AmiManifest {
+	m := &AmiManifest{
+		AccountID:     c.targetAccountID,
+		Region:        c.targetRegion,
+		ImageID:       c.copiedImageID,
+		SourceImageID: c.sourceImageID,
+		SourceRegion:  c.sourceRegion,
+		Name:          c.name,
+		Encrypted:     c.encrypted,
+		KmsKeyID:      c.kmsKeyID,
+		SSMParameter:  c.publishedParameter,
+		Status:        StatusCopied,
+		StartTime:     c.startTime,
+		EndTime:       c.endTime,
+	}
+
+	switch {
+	case err != nil:
+		m.Status = StatusFailed
+		m.Error = err.Error()
+	case c.resumeStatus != "":
+		m.Status = c.resumeStatus
+	case c.reused:
+		m.Status = StatusReused
+	}
+
+	m.SnapshotIDs = imageSnapshotIDs(c.copiedImage)
+	m.FastSnapshotRestoreAZs = c.fastRestoredAZs
+	if m.Name == "" {
+		m.Name = aws.ToString(c.sourceImage.Name)
 	}
-	close(amiManifests)
 
-	return
+	return m
 }
@@ -2,13 +2,29 @@ package ami_copy
 
 import (
 	"context"
+	"crypto/md5"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	registryimage "github.com/hashicorp/packer-plugin-sdk/packer/registry/image"
+	"gopkg.in/yaml.v3"
+
+	"github.com/bdwyertech/packer-plugin-aws/helpers"
 )
 
 func TestPostProcessor_ImplementsPostProcessor(t *testing.T) {
@@ -23,30 +39,44 @@ func TestPostProcessor_Impl(t *testing.T) {
 	}
 }
 
-func TestAmisFromArtifactID(t *testing.T) {
-	artifact := "us-east-1:ami-123,us-west-2:ami-456"
-	amis := amisFromArtifactID(artifact)
+func TestWriteManifestsWritesJSON(t *testing.T) {
+	tmp := t.TempDir() + "/manifest.json"
+	manifests := []*AmiManifest{
+		{AccountID: "111111111111", Region: "us-east-1", ImageID: "ami-abc", SourceImageID: "ami-src", Status: StatusCopied},
+	}
 
-	if len(amis) != 2 {
-		t.Fatalf("expected 2 amis, got %d", len(amis))
+	if err := writeManifests(tmp, ManifestFormatJSON, &Manifest{Version: ManifestVersion, Copies: manifests}); err != nil {
+		t.Fatalf("writeManifests failed: %v", err)
 	}
 
-	if amis[0].region != "us-east-1" || amis[0].id != "ami-123" {
-		t.Fatalf("first ami mismatch: %+v", amis[0])
+	data, err := os.ReadFile(tmp)
+	if err != nil {
+		t.Fatalf("reading manifest file failed: %v", err)
+	}
+
+	var out Manifest
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
 	}
 
-	if amis[1].region != "us-west-2" || amis[1].id != "ami-456" {
-		t.Fatalf("second ami mismatch: %+v", amis[1])
+	if out.Version != ManifestVersion {
+		t.Fatalf("expected manifest version %d, got %d", ManifestVersion, out.Version)
+	}
+	if len(out.Copies) != 1 {
+		t.Fatalf("expected 1 manifest, got %d", len(out.Copies))
+	}
+	if out.Copies[0].ImageID != "ami-abc" || out.Copies[0].AccountID != "111111111111" || out.Copies[0].Region != "us-east-1" {
+		t.Fatalf("manifest content mismatch: %+v", out.Copies[0])
 	}
 }
 
-func TestWriteManifestsWritesJSON(t *testing.T) {
-	tmp := t.TempDir() + "/manifest.json"
+func TestWriteManifestsWritesYAML(t *testing.T) {
+	tmp := t.TempDir() + "/manifest.yaml"
 	manifests := []*AmiManifest{
-		{AccountID: "111111111111", Region: "us-east-1", ImageID: "ami-abc"},
+		{AccountID: "111111111111", Region: "us-east-1", SourceImageID: "ami-src", Status: StatusFailed, Error: "boom"},
 	}
 
-	if err := writeManifests(tmp, manifests); err != nil {
+	if err := writeManifests(tmp, ManifestFormatYAML, &Manifest{Version: ManifestVersion, Copies: manifests}); err != nil {
 		t.Fatalf("writeManifests failed: %v", err)
 	}
 
@@ -55,16 +85,86 @@ func TestWriteManifestsWritesJSON(t *testing.T) {
 		t.Fatalf("reading manifest file failed: %v", err)
 	}
 
-	var out []*AmiManifest
-	if err := json.Unmarshal(data, &out); err != nil {
+	var out Manifest
+	if err := yaml.Unmarshal(data, &out); err != nil {
 		t.Fatalf("unmarshal failed: %v", err)
 	}
+	if out.Version != ManifestVersion || len(out.Copies) != 1 || out.Copies[0].Status != StatusFailed || out.Copies[0].Error != "boom" {
+		t.Fatalf("manifest content mismatch: %+v", out)
+	}
 
-	if len(out) != 1 {
-		t.Fatalf("expected 1 manifest, got %d", len(out))
+	read, err := ReadManifest(tmp)
+	if err != nil {
+		t.Fatalf("ReadManifest failed: %v", err)
 	}
-	if out[0].ImageID != "ami-abc" || out[0].AccountID != "111111111111" || out[0].Region != "us-east-1" {
-		t.Fatalf("manifest content mismatch: %+v", out[0])
+	if len(read.Copies) != 1 || read.Copies[0].Error != "boom" {
+		t.Fatalf("read manifest content mismatch: %+v", read)
+	}
+}
+
+func TestCopyOperation_ManifestRecordsFailure(t *testing.T) {
+	c := &copyOperation{
+		sourceImage: &types.Image{
+			ImageId: aws.String("ami-src"),
+			Name:    aws.String("my-image"),
+		},
+		sourceRegion:    "us-east-1",
+		sourceImageID:   "ami-src",
+		targetRegion:    "eu-west-1",
+		targetAccountID: "111111111111",
+		encrypted:       true,
+		kmsKeyID:        "alias/eu",
+	}
+
+	m := c.manifest(fmt.Errorf("copy denied"))
+	if m.Status != StatusFailed || m.Error != "copy denied" {
+		t.Fatalf("expected a failed entry, got: %+v", m)
+	}
+	if m.SourceImageID != "ami-src" || m.SourceRegion != "us-east-1" || m.Region != "eu-west-1" || m.Name != "my-image" || !m.Encrypted || m.KmsKeyID != "alias/eu" {
+		t.Fatalf("manifest content mismatch: %+v", m)
+	}
+
+	c.copiedImageID = "ami-copy"
+	c.copiedImage = &types.Image{
+		BlockDeviceMappings: []types.BlockDeviceMapping{
+			{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-1")}},
+			{VirtualName: aws.String("ephemeral0")},
+		},
+	}
+	c.reused = true
+	m = c.manifest(nil)
+	if m.Status != StatusReused || m.Error != "" || !slices.Equal(m.SnapshotIDs, []string{"snap-1"}) {
+		t.Fatalf("expected a reused entry with its snapshots, got: %+v", m)
+	}
+}
+
+func TestAppendPackerManifest(t *testing.T) {
+	tmp := t.TempDir() + "/packer-manifest.json"
+	if err := os.WriteFile(tmp, []byte(`{"builds":[{"name":"build","builder_type":"amazon-ebs","artifact_id":"us-east-1:ami-src"}],"last_run_uuid":"old"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PACKER_RUN_UUID", "new")
+
+	artifact := &Artifact{
+		Images: []*CopiedImage{{AccountID: "111111111111", Region: "us-east-1", ImageID: "ami-1"}},
+	}
+	if err := appendPackerManifest(tmp, "build", artifact); err != nil {
+		t.Fatalf("appendPackerManifest failed: %v", err)
+	}
+
+	data, err := os.ReadFile(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out packerManifest
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if len(out.Builds) != 2 || out.LastRunUUID != "new" {
+		t.Fatalf("expected the copy to be appended, got: %+v", out)
+	}
+	if b := out.Builds[1]; b.BuilderType != "ami-copy" || b.ArtifactId != "111111111111:us-east-1:ami-1" || b.PackerRunUUID != "new" {
+		t.Fatalf("unexpected appended build: %+v", b)
 	}
 }
 
@@ -96,10 +196,13 @@ func TestExecuteCopies_TagsOnly_NoClientNeeded(t *testing.T) {
 		},
 	}
 
-	errs := p.executeCopies([]*copyOperation{c}, ui)
+	manifests, errs := p.executeCopies([]*copyOperation{c}, ui, nil)
 	if len(errs.Errors) != 0 {
 		t.Fatalf("expected no errors from executeCopies, got: %v", errs)
 	}
+	if len(manifests) != 1 || manifests[0].Status != StatusCopied {
+		t.Fatalf("expected one copied manifest entry, got: %+v", manifests)
+	}
 
 	if c.copiedImageID != "ami-xyz" {
 		t.Fatalf("expected copiedImageID to be source image id, got %q", c.copiedImageID)
@@ -132,3 +235,1488 @@ func TestCopyExecute_SetsCopiedImageWhenTagsOnly(t *testing.T) {
 		t.Fatalf("expected copiedImageID 'ami-foo', got %q", c.copiedImageID)
 	}
 }
+
+func TestArtifact_ListsEveryCopy(t *testing.T) {
+	artifact := &Artifact{
+		BuilderIdValue: BuilderId,
+		Images: []*CopiedImage{
+			{AccountID: "222222222222", Region: "us-west-2", ImageID: "ami-2", SourceImageID: "ami-src"},
+			{AccountID: "111111111111", Region: "us-east-1", ImageID: "ami-1", SourceImageID: "ami-src"},
+		},
+	}
+
+	if artifact.BuilderId() != "packer.post-processor.ami-copy" {
+		t.Fatalf("unexpected builder id: %s", artifact.BuilderId())
+	}
+
+	if id := artifact.Id(); id != "111111111111:us-east-1:ami-1,222222222222:us-west-2:ami-2" {
+		t.Fatalf("unexpected artifact id: %s", id)
+	}
+
+	images, ok := artifact.State(registryimage.ArtifactStateURI).([]*registryimage.Image)
+	if !ok || len(images) != 2 {
+		t.Fatalf("expected 2 registry images, got %#v", artifact.State(registryimage.ArtifactStateURI))
+	}
+	for _, image := range images {
+		if image.ProviderName != "aws" || image.SourceImageID != "ami-src" || image.Labels["account_id"] == "" {
+			t.Fatalf("unexpected registry image: %+v", image)
+		}
+	}
+}
+
+func TestArtifact_DestroySkipsTagsOnlyCopies(t *testing.T) {
+	artifact := &Artifact{
+		Images: []*CopiedImage{
+			{AccountID: "111111111111", Region: "us-east-1", ImageID: "ami-src", SourceImageID: "ami-src"},
+		},
+	}
+
+	if err := artifact.Destroy(); err != nil {
+		t.Fatalf("expected no error destroying a tags-only copy, got: %v", err)
+	}
+}
+
+func TestNewCopyOperation_DestinationRegions(t *testing.T) {
+	p := PostProcessor{
+		config: Config{
+			DestinationRegions: []string{"us-east-1", "eu-west-1"},
+		},
+	}
+	p.config.AMIKmsKeyId = "alias/default"
+	p.config.AMIRegionKMSKeyIDs = map[string]string{"eu-west-1": "alias/eu"}
+
+	source := &types.Image{ImageId: aws.String("ami-src")}
+	src := &helpers.AMI{ID: "ami-src", Region: "us-east-1"}
+
+	if got := p.destinationRegions(nil, "us-east-1"); !slices.Equal(got, []string{"us-east-1", "eu-west-1"}) {
+		t.Fatalf("unexpected top-level regions: %v", got)
+	}
+
+	tgt := &Target{
+		DestinationRegions: []string{"ap-southeast-2"},
+		RegionKMSKeyIDs:    map[string]string{"ap-southeast-2": "alias/ap"},
+	}
+	if got := p.destinationRegions(tgt.DestinationRegions, "us-east-1"); !slices.Equal(got, []string{"ap-southeast-2"}) {
+		t.Fatalf("unexpected target regions: %v", got)
+	}
+
+	for _, tt := range []struct {
+		region string
+		tgt    *Target
+		want   string
+	}{
+		{"us-east-1", nil, "alias/default"},
+		{"eu-west-1", nil, "alias/eu"},
+		{"ap-southeast-2", tgt, "alias/ap"},
+	} {
+		c := p.newCopyOperation(context.Background(), aws.Config{}, aws.Config{}, source, src, "111111111111", tt.region, tt.tgt)
+		if c.targetRegion != tt.region || c.targetConfig.Region != tt.region || c.sourceRegion != "us-east-1" {
+			t.Fatalf("unexpected regions for %s: target %s, config %s, source %s", tt.region, c.targetRegion, c.targetConfig.Region, c.sourceRegion)
+		}
+		if c.kmsKeyID != tt.want {
+			t.Fatalf("expected kms key %s in %s, got %s", tt.want, tt.region, c.kmsKeyID)
+		}
+	}
+
+	p.config.TagsOnly = true
+	if got := p.destinationRegions(tgt.DestinationRegions, "us-east-1"); !slices.Equal(got, []string{"us-east-1"}) {
+		t.Fatalf("tags_only must stay in the source region, got %v", got)
+	}
+}
+
+// newTestEC2Client returns an EC2 client talking to a local endpoint that
+// answers each action with the XML body returned by respond.
+func newTestEC2Client(t *testing.T, respond func(action string, r *http.Request) string) *ec2.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing request: %v", err)
+		}
+		action := r.Form.Get("Action")
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>test</requestId>%[2]s</%[1]sResponse>`, action, respond(action, r))
+	}))
+	t.Cleanup(server.Close)
+
+	return ec2.NewFromConfig(aws.Config{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+	}, func(o *ec2.Options) {
+		o.BaseEndpoint = aws.String(server.URL)
+	})
+}
+
+func TestCopyExecute_ReusesExistingCopy(t *testing.T) {
+	ui := packersdk.TestUi(t)
+
+	var tagged []string
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		switch action {
+		case "DescribeImages":
+			if r.Form.Get("Filter.1.Name") != "tag:"+SourceAMITag || r.Form.Get("Filter.1.Value.1") != "ami-src" {
+				return "<imagesSet/>"
+			}
+			return `<imagesSet>
+				<item><imageId>ami-src</imageId><imageState>available</imageState></item>
+				<item><imageId>ami-old</imageId><imageState>failed</imageState></item>
+				<item><imageId>ami-copy</imageId><imageState>available</imageState></item>
+			</imagesSet>`
+		case "CreateTags":
+			tagged = append(tagged, r.Form.Get("ResourceId.1"))
+			return "<return>true</return>"
+		default:
+			t.Errorf("unexpected %s request", action)
+			return ""
+		}
+	})
+
+	c := &copyOperation{
+		ctx:             context.Background(),
+		client:          client,
+		sourceImage:     &types.Image{ImageId: aws.String("ami-src"), Name: aws.String("my-image")},
+		sourceRegion:    "us-east-1",
+		sourceImageID:   "ami-src",
+		targetRegion:    "us-east-1",
+		targetAccountID: "111111111111",
+	}
+
+	if err := c.execute(ui); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+
+	if c.copiedImageID != "ami-copy" || !c.reused {
+		t.Fatalf("expected ami-copy to be reused, got %q (reused: %t)", c.copiedImageID, c.reused)
+	}
+	if !slices.Equal(tagged, []string{"ami-copy"}) {
+		t.Fatalf("expected the reused copy to be re-tagged, got %v", tagged)
+	}
+}
+
+func TestFindExistingCopy_RejectsNameMatchesOfOtherSources(t *testing.T) {
+	byName := `<item><imageId>ami-other</imageId><imageState>available</imageState><sourceImageId>ami-x</sourceImageId></item>
+		<item><imageId>ami-tagged</imageId><imageState>available</imageState>
+			<tagSet><item><key>` + SourceAMITag + `</key><value>ami-y</value></item></tagSet>
+		</item>`
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		if action != "DescribeImages" {
+			t.Errorf("unexpected %s request", action)
+		}
+		if r.Form.Get("Filter.1.Name") != "name" {
+			return "<imagesSet/>"
+		}
+		return "<imagesSet>" + byName + "</imagesSet>"
+	})
+
+	c := &copyOperation{ctx: context.Background(), client: client, sourceImageID: "ami-src"}
+	existing, err := c.findExistingCopy("my-image")
+	if err != nil || existing != nil {
+		t.Fatalf("expected no copy of ami-src, got %v (%v)", existing, err)
+	}
+
+	byName += `<item><imageId>ami-copy</imageId><imageState>available</imageState><sourceImageId>ami-src</sourceImageId></item>`
+	existing, err = c.findExistingCopy("my-image")
+	if err != nil || existing == nil || aws.ToString(existing.ImageId) != "ami-copy" {
+		t.Fatalf("expected ami-copy, got %v (%v)", existing, err)
+	}
+}
+
+func TestRetentionConfig_Expired(t *testing.T) {
+	now := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)
+	images := []types.Image{
+		{ImageId: aws.String("ami-1"), CreationDate: aws.String("2024-01-01T00:00:00.000Z")},
+		{ImageId: aws.String("ami-4"), CreationDate: aws.String("2024-06-29T00:00:00.000Z")},
+		{ImageId: aws.String("ami-2"), CreationDate: aws.String("2024-03-01T00:00:00.000Z")},
+		{ImageId: aws.String("ami-3"), CreationDate: aws.String("2024-06-01T00:00:00.000Z"), State: types.ImageStatePending},
+		{ImageId: aws.String("ami-new"), CreationDate: aws.String("2024-06-30T00:00:00.000Z")},
+	}
+
+	ids := func(images []types.Image) (out []string) {
+		for _, image := range images {
+			out = append(out, *image.ImageId)
+		}
+		return out
+	}
+
+	for _, tt := range []struct {
+		name   string
+		config RetentionConfig
+		want   []string
+	}{
+		{"keep_last", RetentionConfig{KeepLast: 2}, []string{"ami-2", "ami-1"}},
+		{"keep_days", RetentionConfig{KeepDays: 7}, []string{"ami-2", "ami-1"}},
+		{"keep_days keeps recent beyond keep_last", RetentionConfig{KeepLast: 1, KeepDays: 7}, []string{"ami-2", "ami-1"}},
+		{"keep_last keeps old within count", RetentionConfig{KeepLast: 4, KeepDays: 1}, []string{"ami-1"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(tt.config.expired(images, []string{"ami-new"}, now)); !slices.Equal(got, tt.want) {
+				t.Fatalf("expected %v to expire, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPostProcessorConfigure_Retention(t *testing.T) {
+	p := &PostProcessor{}
+	err := p.Configure(map[string]any{
+		"ami_users": []string{"111111111111"},
+		"retention": map[string]any{"keep_last": 3},
+	})
+	if err == nil || !strings.Contains(err.Error(), "group_tag") {
+		t.Fatalf("expected group_tag to be required, got: %v", err)
+	}
+
+	p = &PostProcessor{}
+	if err := p.Configure(map[string]any{
+		"ami_users": []string{"111111111111"},
+		"retention": map[string]any{"keep_last": 3, "group_tag": "Name"},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !p.config.Retention.enabled() {
+		t.Fatalf("expected retention to be enabled")
+	}
+}
+
+func TestPruneCopies_RemovesExpiredCopies(t *testing.T) {
+	ui := packersdk.TestUi(t)
+
+	var unprotected, deregistered, deleted []string
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		switch action {
+		case "DescribeImages":
+			if r.Form.Get("Filter.1.Name") != "tag:Name" || r.Form.Get("Filter.1.Value.1") != "golden" {
+				t.Errorf("unexpected filters: %v", r.Form)
+			}
+			return `<imagesSet>
+				<item><imageId>ami-new</imageId><imageState>available</imageState><creationDate>2024-06-30T00:00:00.000Z</creationDate></item>
+				<item><imageId>ami-old</imageId><imageState>available</imageState><creationDate>2024-01-01T00:00:00.000Z</creationDate>
+					<deregistrationProtection>enabled</deregistrationProtection>
+					<blockDeviceMapping><item><deviceName>/dev/sda1</deviceName><ebs><snapshotId>snap-old</snapshotId></ebs></item></blockDeviceMapping>
+				</item>
+			</imagesSet>`
+		case "DisableImageDeregistrationProtection":
+			unprotected = append(unprotected, r.Form.Get("ImageId"))
+			return "<return>disabled</return>"
+		case "DeregisterImage":
+			if !slices.Contains(unprotected, r.Form.Get("ImageId")) {
+				t.Errorf("%s deregistered with deregistration protection", r.Form.Get("ImageId"))
+			}
+			deregistered = append(deregistered, r.Form.Get("ImageId"))
+			return "<return>true</return>"
+		case "DeleteSnapshot":
+			deleted = append(deleted, r.Form.Get("SnapshotId"))
+			return "<return>true</return>"
+		default:
+			t.Errorf("unexpected %s request", action)
+			return ""
+		}
+	})
+
+	p := &PostProcessor{config: Config{Retention: RetentionConfig{KeepLast: 1, GroupTag: "Name"}}}
+	copies := []*copyOperation{{
+		client:          client,
+		sourceImage:     &types.Image{Name: aws.String("golden"), Tags: []types.Tag{{Key: aws.String("Name"), Value: aws.String("source")}}},
+		sourceImageID:   "ami-src",
+		copiedImageID:   "ami-new",
+		tags:            map[string]string{"Name": "{{ .SourceAMIName }}"},
+		targetRegion:    "us-east-1",
+		targetAccountID: "111111111111",
+	}}
+
+	// Protected copies are kept without force
+	if pruned := p.pruneCopies(context.Background(), ui, copies); len(pruned) != 0 || len(deregistered) != 0 {
+		t.Fatalf("expected the protected ami-old to be kept, got %+v", pruned)
+	}
+
+	p.config.Retention.Force = true
+	pruned := p.pruneCopies(context.Background(), ui, copies)
+	if len(pruned) != 1 || pruned[0].ImageID != "ami-old" || !slices.Equal(pruned[0].SnapshotIDs, []string{"snap-old"}) || pruned[0].Error != "" {
+		t.Fatalf("unexpected pruned copies: %+v", pruned)
+	}
+	if !slices.Equal(deregistered, []string{"ami-old"}) || !slices.Equal(deleted, []string{"snap-old"}) {
+		t.Fatalf("expected ami-old and its snapshot to be removed, got %v and %v", deregistered, deleted)
+	}
+
+	deregistered, deleted = nil, nil
+	p.config.Retention.DryRun = true
+	pruned = p.pruneCopies(context.Background(), ui, copies)
+	if len(pruned) != 1 || !pruned[0].DryRun || len(deregistered) != 0 || len(deleted) != 0 {
+		t.Fatalf("expected dry_run to only report ami-old, got %+v", pruned)
+	}
+}
+
+func TestOrganizationLaunchPermissions(t *testing.T) {
+	p := &PostProcessor{}
+	p.config.AMIOrgArns = []string{"arn:aws:organizations::123456789012:organization/o-abc"}
+	p.config.AMIOuArns = []string{"arn:aws:organizations::123456789012:ou/o-abc/ou-def"}
+
+	var grantees []string
+	for _, permission := range p.organizationLaunchPermissions() {
+		grantees = append(grantees, helpers.LaunchPermissionGrantee(permission))
+	}
+	if !slices.Equal(grantees, []string{
+		"organization arn:aws:organizations::123456789012:organization/o-abc",
+		"organizational unit arn:aws:organizations::123456789012:ou/o-abc/ou-def",
+	}) {
+		t.Fatalf("unexpected launch permissions for %v", grantees)
+	}
+}
+
+func TestNewCopyOperation_InheritsDeprecationAndProtection(t *testing.T) {
+	p := PostProcessor{}
+	source := &types.Image{
+		ImageId:                  aws.String("ami-src"),
+		DeprecationTime:          aws.String("2030-01-01T00:00:00.000Z"),
+		DeregistrationProtection: aws.String("enabled-with-cooldown"),
+	}
+	src := &helpers.AMI{ID: "ami-src", Region: "us-east-1"}
+
+	c := p.newCopyOperation(context.Background(), aws.Config{}, aws.Config{}, source, src, "111111111111", "us-east-1", nil)
+	if c.deprecateAt != "2030-01-01T00:00:00.000Z" || !c.deregistrationProtection.Enabled || !c.deregistrationProtection.WithCooldown {
+		t.Fatalf("expected settings inherited from the source, got %q and %+v", c.deprecateAt, c.deregistrationProtection)
+	}
+
+	tgt := &Target{
+		DeprecateAt:              "2031-01-01T00:00:00Z",
+		DeregistrationProtection: &awscommon.DeregistrationProtectionOptions{},
+	}
+	c = p.newCopyOperation(context.Background(), aws.Config{}, aws.Config{}, source, src, "111111111111", "us-east-1", tgt)
+	if c.deprecateAt != "2031-01-01T00:00:00Z" || c.deregistrationProtection.Enabled {
+		t.Fatalf("expected target settings, got %q and %+v", c.deprecateAt, c.deregistrationProtection)
+	}
+
+	// A top-level setting, even disabled, is not overridden by the source
+	p = PostProcessor{}
+	if err := p.Configure(map[string]any{
+		"ami_users":                 []string{"111111111111"},
+		"deregistration_protection": map[string]any{"enabled": false},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c = p.newCopyOperation(context.Background(), aws.Config{}, aws.Config{}, source, src, "111111111111", "us-east-1", nil)
+	if c.deregistrationProtection.Enabled {
+		t.Fatalf("expected the top-level setting, got %+v", c.deregistrationProtection)
+	}
+}
+
+func TestNewCopyOperation_InheritsProtectionState(t *testing.T) {
+	src := &helpers.AMI{ID: "ami-src", Region: "us-east-1"}
+	for _, tt := range []struct {
+		state string
+		want  awscommon.DeregistrationProtectionOptions
+	}{
+		{state: "enabled-with-cooldown", want: awscommon.DeregistrationProtectionOptions{Enabled: true, WithCooldown: true}},
+		{state: "enabled-without-cooldown", want: awscommon.DeregistrationProtectionOptions{Enabled: true}},
+		{state: "disabled", want: awscommon.DeregistrationProtectionOptions{}},
+	} {
+		t.Run(tt.state, func(t *testing.T) {
+			p := PostProcessor{}
+			source := &types.Image{ImageId: aws.String("ami-src"), DeregistrationProtection: aws.String(tt.state)}
+			c := p.newCopyOperation(context.Background(), aws.Config{}, aws.Config{}, source, src, "111111111111", "us-east-1", nil)
+			if c.deregistrationProtection != tt.want {
+				t.Fatalf("expected %+v, got %+v", tt.want, c.deregistrationProtection)
+			}
+		})
+	}
+}
+
+func TestCopyExecute_AppliesDeprecationAndProtection(t *testing.T) {
+	ui := packersdk.TestUi(t)
+
+	var calls []string
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		calls = append(calls, action)
+		switch action {
+		case "GetImageBlockPublicAccessState":
+			return "<imageBlockPublicAccessState>block-new-sharing</imageBlockPublicAccessState>"
+		case "DescribeImages":
+			return `<imagesSet><item><imageId>ami-copy</imageId><imageState>available</imageState></item></imagesSet>`
+		case "CreateTags", "EnableImageDeprecation":
+			return "<return>true</return>"
+		case "EnableImageDeregistrationProtection":
+			if r.Form.Get("WithCooldown") != "true" {
+				t.Errorf("expected a cooldown, got %v", r.Form)
+			}
+			return "<return>true</return>"
+		default:
+			t.Errorf("unexpected %s request", action)
+			return ""
+		}
+	})
+
+	c := &copyOperation{
+		ctx:                      context.Background(),
+		client:                   client,
+		sourceImage:              &types.Image{ImageId: aws.String("ami-src")},
+		sourceRegion:             "us-east-1",
+		sourceImageID:            "ami-src",
+		targetRegion:             "us-east-1",
+		targetAccountID:          "111111111111",
+		deprecateAt:              "2030-01-01T00:00:00Z",
+		deregistrationProtection: awscommon.DeregistrationProtectionOptions{Enabled: true, WithCooldown: true},
+		requireBlockPublicAccess: true,
+		copyTimeout:              time.Minute,
+		pollInterval:             time.Millisecond,
+	}
+
+	if err := c.execute(ui); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	for _, action := range []string{"GetImageBlockPublicAccessState", "EnableImageDeprecation", "EnableImageDeregistrationProtection"} {
+		if !slices.Contains(calls, action) {
+			t.Fatalf("expected a %s request, got %v", action, calls)
+		}
+	}
+}
+
+func TestCopyExecute_RefusesWithoutBlockPublicAccess(t *testing.T) {
+	ui := packersdk.TestUi(t)
+
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		if action != "GetImageBlockPublicAccessState" {
+			t.Errorf("unexpected %s request", action)
+		}
+		return "<imageBlockPublicAccessState>unblocked</imageBlockPublicAccessState>"
+	})
+
+	c := &copyOperation{
+		ctx:                      context.Background(),
+		client:                   client,
+		sourceImage:              &types.Image{ImageId: aws.String("ami-src")},
+		sourceImageID:            "ami-src",
+		targetRegion:             "us-east-1",
+		targetAccountID:          "111111111111",
+		requireBlockPublicAccess: true,
+	}
+
+	if err := c.execute(ui); err == nil || !strings.Contains(err.Error(), "unblocked") {
+		t.Fatalf("expected the copy to be refused, got: %v", err)
+	}
+}
+
+func TestCopyOperation_PublishParameter(t *testing.T) {
+	ui := packersdk.TestUi(t)
+
+	var input map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "AmazonSSM.PutParameter" {
+			t.Errorf("unexpected %s request", target)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprint(w, `{"Version":1,"Tier":"Standard"}`)
+	}))
+	defer server.Close()
+
+	parameter := &SSMParameterConfig{
+		Name:      "/golden/{{ .SourceAMITags.OS }}/{{ .Region }}/latest",
+		Overwrite: true,
+		DataType:  "aws:ec2:image",
+	}
+	if errs := parameter.Prepare(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	c := &copyOperation{
+		ctx: context.Background(),
+		targetConfig: aws.Config{
+			Region:       "eu-west-1",
+			Credentials:  aws.AnonymousCredentials{},
+			BaseEndpoint: aws.String(server.URL),
+		},
+		sourceImage: &types.Image{
+			ImageId: aws.String("ami-src"),
+			Tags:    []types.Tag{{Key: aws.String("OS"), Value: aws.String("windows2022")}},
+		},
+		sourceImageID:   "ami-src",
+		copiedImageID:   "ami-copy",
+		targetRegion:    "eu-west-1",
+		targetAccountID: "111111111111",
+		ssmParameter:    parameter,
+	}
+
+	if err := c.publishParameter(ui); err != nil {
+		t.Fatalf("publishParameter failed: %v", err)
+	}
+	if input["Name"] != "/golden/windows2022/eu-west-1/latest" || input["Value"] != "ami-copy" ||
+		input["Overwrite"] != true || input["Tier"] != "Standard" || input["DataType"] != "aws:ec2:image" {
+		t.Fatalf("unexpected PutParameter input: %v", input)
+	}
+	if m := c.manifest(nil); m.SSMParameter != "/golden/windows2022/eu-west-1/latest" {
+		t.Fatalf("expected the parameter in the manifest, got %+v", m)
+	}
+}
+
+func TestCopyExecute_WaitsBeforePublishingImageParameter(t *testing.T) {
+	ui := packersdk.TestUi(t)
+
+	var available bool
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		switch action {
+		case "DescribeImages":
+			if r.Form.Get("Filter.1.Name") != "image-id" {
+				return "<imagesSet/>"
+			}
+			available = true
+			return `<imagesSet><item><imageId>ami-copy</imageId><imageState>available</imageState></item></imagesSet>`
+		case "CopyImage":
+			return "<imageId>ami-copy</imageId>"
+		default:
+			t.Errorf("unexpected %s request", action)
+			return ""
+		}
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available {
+			t.Errorf("parameter published before the copy was available")
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprint(w, `{"Version":1,"Tier":"Standard"}`)
+	}))
+	defer server.Close()
+
+	parameter := &SSMParameterConfig{Name: "/golden/latest", DataType: "aws:ec2:image"}
+	if errs := parameter.Prepare(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	c := &copyOperation{
+		ctx:    context.Background(),
+		client: client,
+		targetConfig: aws.Config{
+			Region:       "us-east-1",
+			Credentials:  aws.AnonymousCredentials{},
+			BaseEndpoint: aws.String(server.URL),
+		},
+		sourceImage:     &types.Image{ImageId: aws.String("ami-src")},
+		sourceRegion:    "us-east-1",
+		sourceImageID:   "ami-src",
+		targetRegion:    "us-east-1",
+		targetAccountID: "111111111111",
+		ssmParameter:    parameter,
+		copyTimeout:     time.Minute,
+		pollInterval:    time.Millisecond,
+	}
+
+	if err := c.execute(ui); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	if !available {
+		t.Fatal("expected the copy to be waited for")
+	}
+}
+
+func TestSSMParameterConfig_Prepare(t *testing.T) {
+	parameter := &SSMParameterConfig{Name: "/golden/latest", Tier: "Premium", DataType: "json"}
+	if errs := parameter.Prepare(); len(errs) != 2 {
+		t.Fatalf("expected tier and data_type errors, got %v", errs)
+	}
+
+	parameter = &SSMParameterConfig{}
+	if errs := parameter.Prepare(); len(errs) != 0 || parameter.Tier != "" {
+		t.Fatalf("expected an unset parameter to be left alone, got %v", errs)
+	}
+
+	// The name is rendered for each copy, not when configuring
+	p := &PostProcessor{}
+	if err := p.Configure(map[string]any{
+		"ami_users":     []string{"111111111111"},
+		"ssm_parameter": map[string]any{"name": "/golden/{{ .Region }}/latest"},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.config.SSMParameter.Name != "/golden/{{ .Region }}/latest" || p.config.SSMParameter.Tier != "Standard" {
+		t.Fatalf("unexpected ssm_parameter: %+v", p.config.SSMParameter)
+	}
+}
+
+func TestNotifier_PublishesToSNSAndSQS(t *testing.T) {
+	ui := packersdk.TestUi(t)
+
+	var published, sent []Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+		switch {
+		case r.Header.Get("X-Amz-Target") == "AmazonSQS.SendMessage":
+			var input struct {
+				QueueUrl          string
+				MessageBody       string
+				MessageAttributes map[string]struct{ StringValue string }
+			}
+			if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+				t.Errorf("decoding request: %v", err)
+			}
+			if err := json.Unmarshal([]byte(input.MessageBody), &n); err != nil {
+				t.Errorf("decoding message: %v", err)
+			}
+			if input.MessageAttributes["event"].StringValue != n.Event {
+				t.Errorf("unexpected attributes: %v", input.MessageAttributes)
+			}
+			sent = append(sent, n)
+			w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+			fmt.Fprintf(w, `{"MessageId":"1","MD5OfMessageBody":"%x"}`, md5.Sum([]byte(input.MessageBody)))
+		default:
+			if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "Publish" {
+				t.Errorf("unexpected request: %v", r.Form)
+			}
+			if r.Form.Get("TopicArn") != "arn:aws:sns:eu-west-1:111111111111:amis" {
+				t.Errorf("unexpected topic: %s", r.Form.Get("TopicArn"))
+			}
+			if err := json.Unmarshal([]byte(r.Form.Get("Message")), &n); err != nil {
+				t.Errorf("decoding message: %v", err)
+			}
+			published = append(published, n)
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprint(w, `<PublishResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/"><PublishResult><MessageId>1</MessageId></PublishResult></PublishResponse>`)
+		}
+	}))
+	defer server.Close()
+
+	configs := []NotificationConfig{
+		{SNSTopicArn: "arn:aws:sns:eu-west-1:111111111111:amis"},
+		{SQSQueueURL: "https://sqs.us-east-1.amazonaws.com/111111111111/amis"},
+	}
+	for _, config := range configs {
+		if errs := config.Prepare(); len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+	}
+
+	n := &notifier{
+		configs: configs,
+		source: aws.Config{
+			Region:       "us-east-1",
+			Credentials:  aws.AnonymousCredentials{},
+			BaseEndpoint: aws.String(server.URL),
+		},
+	}
+	m := &AmiManifest{AccountID: "111111111111", ImageID: "ami-copy", SourceImageID: "ami-src", Status: StatusFailed, Error: "boom"}
+	n.notifyCopy(context.Background(), ui, &copyOperation{}, m)
+	n.notifySummary(context.Background(), ui, &Manifest{Version: ManifestVersion, Copies: []*AmiManifest{m}})
+
+	for _, got := range [][]Notification{published, sent} {
+		if len(got) != 2 || got[0].Event != EventCopy || got[0].Copy.Error != "boom" ||
+			got[1].Event != EventSummary || len(got[1].Summary.Copies) != 1 {
+			t.Fatalf("unexpected notifications: %+v", got)
+		}
+	}
+}
+
+func TestNotificationConfig_Prepare(t *testing.T) {
+	for _, config := range []NotificationConfig{
+		{},
+		{SNSTopicArn: "arn:aws:sns:us-east-1:111111111111:amis", SQSQueueURL: "https://sqs.us-east-1.amazonaws.com/111111111111/amis"},
+		{SNSTopicArn: "amis"},
+	} {
+		if errs := config.Prepare(); len(errs) == 0 {
+			t.Fatalf("expected %+v to be invalid", config)
+		}
+	}
+
+	if region := sqsQueueRegion("https://sqs.ap-southeast-2.amazonaws.com/111111111111/amis"); region != "ap-southeast-2" {
+		t.Fatalf("unexpected queue region: %s", region)
+	}
+}
+
+func TestCopyExecute_RendersTargetNameAndTags(t *testing.T) {
+	ui := packersdk.TestUi(t)
+
+	var copyInput url.Values
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		switch action {
+		case "DescribeImages":
+			return "<imagesSet/>"
+		case "CopyImage":
+			copyInput = r.Form
+			return "<imageId>ami-copy</imageId>"
+		default:
+			t.Errorf("unexpected %s request", action)
+			return ""
+		}
+	})
+
+	p := PostProcessor{}
+	p.config.AMITags = map[string]string{"Team": "platform", "OS": "override-me"}
+	p.config.SnapshotTags = map[string]string{"Source": "{{ .SourceAMI }}"}
+	tgt := &Target{
+		AMIName:        "{{ .SourceAMIName }}-{{ .TargetAccountID }}",
+		AMIDescription: "{{ .SourceAMITags.OS }} in {{ .Region }}",
+		Tags:           map[string]string{"OS": "{{ .SourceAMITags.OS }}-hardened"},
+		SnapshotTags:   map[string]string{"Account": "{{ .TargetAccountID }}"},
+	}
+	source := &types.Image{
+		ImageId: aws.String("ami-src"),
+		Name:    aws.String("golden"),
+		Tags:    []types.Tag{{Key: aws.String("OS"), Value: aws.String("windows2022")}},
+	}
+
+	c := p.newCopyOperation(context.Background(), aws.Config{}, aws.Config{}, source, &helpers.AMI{ID: "ami-src", Region: "us-east-1"}, "111111111111", "eu-west-1", tgt)
+	c.client = client
+
+	if err := c.execute(ui); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+
+	if copyInput.Get("Name") != "golden-111111111111" || copyInput.Get("Description") != "windows2022 in eu-west-1" {
+		t.Fatalf("unexpected name and description: %v", copyInput)
+	}
+
+	specs := map[string]map[string]string{}
+	for i := 1; copyInput.Get(fmt.Sprintf("TagSpecification.%d.ResourceType", i)) != ""; i++ {
+		tags := map[string]string{}
+		for j := 1; copyInput.Get(fmt.Sprintf("TagSpecification.%d.Tag.%d.Key", i, j)) != ""; j++ {
+			tags[copyInput.Get(fmt.Sprintf("TagSpecification.%d.Tag.%d.Key", i, j))] = copyInput.Get(fmt.Sprintf("TagSpecification.%d.Tag.%d.Value", i, j))
+		}
+		specs[copyInput.Get(fmt.Sprintf("TagSpecification.%d.ResourceType", i))] = tags
+	}
+	if image := specs["image"]; image["OS"] != "windows2022-hardened" || image["Team"] != "platform" || image[SourceAMITag] != "ami-src" || len(image) != 3 {
+		t.Fatalf("unexpected image tags: %v", image)
+	}
+	if snapshot := specs["snapshot"]; snapshot["Source"] != "ami-src" || snapshot["Account"] != "111111111111" || len(snapshot) != 2 {
+		t.Fatalf("unexpected snapshot tags: %v", snapshot)
+	}
+	if p.config.AMITags["OS"] != "override-me" {
+		t.Fatalf("target tags must not leak into the top-level tags")
+	}
+}
+
+func TestPostProcessorConfigure_InterpolatesTargets(t *testing.T) {
+	p := &PostProcessor{}
+	if err := p.Configure(map[string]any{
+		"packer_build_name":     "golden",
+		"packer_user_variables": map[string]string{"key": "AKID", "env": "prod"},
+	}, map[string]any{
+		"ami_name": "top-{{ .SourceAMIName }}",
+		"tags":     map[string]string{"Build": "{{ build_name }}"},
+		"targets": []map[string]any{{
+			"access_key":         "{{user `key`}}",
+			"region_kms_key_ids": map[string]string{"eu-west-1": "{{user `env`}}-key"},
+			"ami_name":           "{{ .SourceAMIName }}-{{ .TargetAccountID }}",
+			"tags":               map[string]string{"Env": "{{user `env`}}"},
+		}},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tgt := &p.config.Targets[0]
+	if tgt.AccessKey != "AKID" || tgt.RegionKMSKeyIDs["eu-west-1"] != "prod-key" {
+		t.Fatalf("expected the target access config to be interpolated, got %q and %v", tgt.AccessKey, tgt.RegionKMSKeyIDs)
+	}
+	if tgt.AMIName != "{{ .SourceAMIName }}-{{ .TargetAccountID }}" || tgt.Tags["Env"] != "{{user `env`}}" {
+		t.Fatalf("expected the target templates to be left for each copy, got %q and %v", tgt.AMIName, tgt.Tags)
+	}
+
+	source := &types.Image{ImageId: aws.String("ami-src"), Name: aws.String("base")}
+	ami := &helpers.AMI{ID: "ami-src", Region: "us-east-1"}
+	c := p.newCopyOperation(context.Background(), aws.Config{}, aws.Config{}, source, ami, "111111111111", "eu-west-1", tgt)
+	if name, err := c.render(c.nameTemplate, ""); err != nil || name != "base-111111111111" {
+		t.Fatalf("unexpected name %q (err: %v)", name, err)
+	}
+	tags, err := c.imageTags()
+	if err != nil {
+		t.Fatalf("rendering tags failed: %v", err)
+	}
+	rendered := map[string]string{}
+	for _, tag := range tags {
+		rendered[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	if rendered["Env"] != "prod" || rendered["Build"] != "golden" {
+		t.Fatalf("expected user variables and the build name in the tags, got %v", rendered)
+	}
+
+	// The top-level ami_name only applies with rename_copies
+	if c := p.newCopyOperation(context.Background(), aws.Config{}, aws.Config{}, source, ami, "222222222222", "us-east-1", nil); c.nameTemplate != "" {
+		t.Fatalf("expected copies to keep the source name, got %q", c.nameTemplate)
+	}
+	p.config.RenameCopies = true
+	c = p.newCopyOperation(context.Background(), aws.Config{}, aws.Config{}, source, ami, "222222222222", "us-east-1", nil)
+	if name, err := c.render(c.nameTemplate, ""); err != nil || name != "top-base" {
+		t.Fatalf("unexpected name %q with rename_copies (err: %v)", name, err)
+	}
+}
+
+func TestWaitForAvailable_ReportsSnapshotProgress(t *testing.T) {
+	ui := &packersdk.MockUi{}
+
+	var describes int
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		switch action {
+		case "DescribeImages":
+			describes++
+			state := "pending"
+			if describes == 3 {
+				state = "available"
+			}
+			return `<imagesSet><item><imageId>ami-copy</imageId><imageState>` + state + `</imageState>
+				<blockDeviceMapping><item><deviceName>/dev/sda1</deviceName><ebs><snapshotId>snap-1</snapshotId></ebs></item></blockDeviceMapping>
+			</item></imagesSet>`
+		case "DescribeSnapshots":
+			return `<snapshotSet><item><snapshotId>snap-1</snapshotId><progress>` + strconv.Itoa(describes*40) + `%</progress></item></snapshotSet>`
+		default:
+			t.Errorf("unexpected %s request", action)
+			return ""
+		}
+	})
+
+	c := &copyOperation{
+		ctx:             context.Background(),
+		client:          client,
+		copiedImageID:   "ami-copy",
+		targetAccountID: "111111111111",
+		targetRegion:    "us-east-1",
+		copyTimeout:     time.Minute,
+		pollInterval:    time.Millisecond,
+	}
+
+	if err := c.waitForAvailable(ui); err != nil {
+		t.Fatalf("waitForAvailable failed: %v", err)
+	}
+	if describes != 3 {
+		t.Fatalf("expected 3 polls, got %d", describes)
+	}
+	if !strings.Contains(ui.SayMessages[len(ui.SayMessages)-1].Message, "snap-1 80%") {
+		t.Fatalf("expected snapshot progress to be reported, got %+v", ui.SayMessages)
+	}
+}
+
+func TestWaitForAvailable_StopsOnCancel(t *testing.T) {
+	ui := packersdk.TestUi(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		cancel()
+		return `<imagesSet><item><imageId>ami-copy</imageId><imageState>pending</imageState></item></imagesSet>`
+	})
+
+	c := &copyOperation{
+		ctx:             ctx,
+		client:          client,
+		copiedImageID:   "ami-copy",
+		targetAccountID: "111111111111",
+		copyTimeout:     time.Hour,
+		pollInterval:    time.Minute,
+	}
+
+	if err := c.waitForAvailable(ui); err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("expected the wait to be cancelled, got: %v", err)
+	}
+}
+
+func TestWaitForAvailable_TimesOut(t *testing.T) {
+	ui := packersdk.TestUi(t)
+
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		return `<imagesSet><item><imageId>ami-copy</imageId><imageState>pending</imageState></item></imagesSet>`
+	})
+
+	c := &copyOperation{
+		ctx:             context.Background(),
+		client:          client,
+		copiedImageID:   "ami-copy",
+		targetAccountID: "111111111111",
+		copyTimeout:     50 * time.Millisecond,
+		pollInterval:    10 * time.Millisecond,
+	}
+
+	if err := c.waitForAvailable(ui); err == nil || !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Fatalf("expected the wait to time out, got: %v", err)
+	}
+}
+
+func TestEnableFastRestore_EnablesMissingZones(t *testing.T) {
+	ui := packersdk.TestUi(t)
+
+	var describes int
+	var enabled []string
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		switch action {
+		case "DescribeImages":
+			return `<imagesSet><item><imageId>ami-copy</imageId><imageState>available</imageState>
+				<blockDeviceMapping><item><deviceName>/dev/sda1</deviceName><ebs><snapshotId>snap-1</snapshotId></ebs></item></blockDeviceMapping>
+			</item></imagesSet>`
+		case "DescribeAvailabilityZones":
+			return `<availabilityZoneInfo><item><zoneName>us-east-1a</zoneName></item><item><zoneName>us-east-1b</zoneName></item></availabilityZoneInfo>`
+		case "DescribeFastSnapshotRestores":
+			describes++
+			stateB := "optimizing"
+			switch describes {
+			case 1:
+				stateB = "disabled"
+			case 3:
+				stateB = "enabled"
+			}
+			return `<fastSnapshotRestoreSet>
+				<item><snapshotId>snap-1</snapshotId><availabilityZone>us-east-1a</availabilityZone><state>enabled</state></item>
+				<item><snapshotId>snap-1</snapshotId><availabilityZone>us-east-1b</availabilityZone><state>` + stateB + `</state></item>
+			</fastSnapshotRestoreSet>`
+		case "EnableFastSnapshotRestores":
+			enabled = append(enabled, r.Form.Get("AvailabilityZone.1")+" "+r.Form.Get("SourceSnapshotId.1"))
+			return `<successful/><unsuccessful/>`
+		default:
+			t.Errorf("unexpected %s request", action)
+			return ""
+		}
+	})
+
+	c := &copyOperation{
+		ctx:             context.Background(),
+		client:          client,
+		copiedImageID:   "ami-copy",
+		targetAccountID: "111111111111",
+		targetRegion:    "us-east-1",
+		copyTimeout:     time.Minute,
+		pollInterval:    time.Millisecond,
+		sourceImage:     &types.Image{},
+		fastLaunch:      true,
+	}
+
+	if err := c.enableFastRestore(ui); err != nil {
+		t.Fatalf("enableFastRestore failed: %v", err)
+	}
+	// Zone a was already enabled and must not be disabled on destroy
+	if !slices.Equal(enabled, []string{"us-east-1b snap-1"}) {
+		t.Fatalf("unexpected enabled restores: %v", enabled)
+	}
+	if len(c.fastRestores) != 1 || !slices.Equal(c.fastRestores["us-east-1b"], []string{"snap-1"}) {
+		t.Fatalf("unexpected recorded restores: %v", c.fastRestores)
+	}
+	if describes != 3 {
+		t.Fatalf("expected to poll until enabled, got %d describes", describes)
+	}
+	if m := c.manifest(nil); !slices.Equal(m.FastSnapshotRestoreAZs, []string{"us-east-1a", "us-east-1b"}) {
+		t.Fatalf("unexpected manifest zones: %v", m.FastSnapshotRestoreAZs)
+	}
+}
+
+func TestFastRestoreZones(t *testing.T) {
+	c := &copyOperation{
+		targetRegion:   "eu-west-1",
+		fastRestoreAZs: []string{"us-east-1a", "eu-west-1b", "eu-west-1c"},
+	}
+	if zones := c.fastRestoreZones(); !slices.Equal(zones, []string{"eu-west-1b", "eu-west-1c"}) {
+		t.Fatalf("unexpected zones: %v", zones)
+	}
+	if !c.fastRestoreEnabled() {
+		t.Fatalf("expected fast restore to be enabled by the zones of the region")
+	}
+
+	c.targetRegion = "us-west-2"
+	if c.fastRestoreEnabled() {
+		t.Fatalf("expected fast restore to be disabled without zones in the region")
+	}
+}
+
+func TestArtifact_DestroyDisablesFastRestore(t *testing.T) {
+	var actions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing request: %v", err)
+		}
+		action := r.Form.Get("Action")
+		actions = append(actions, action+" "+r.Form.Get("AvailabilityZone.1"))
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<%[1]sResponse><successful/><unsuccessful/></%[1]sResponse>`, action)
+	}))
+	defer server.Close()
+
+	artifact := &Artifact{
+		Images: []*CopiedImage{{
+			AccountID: "111111111111",
+			Region:    "us-east-1",
+			ImageID:   "ami-reused",
+			config: aws.Config{
+				Credentials:  aws.AnonymousCredentials{},
+				BaseEndpoint: aws.String(server.URL),
+			},
+			fastRestores: map[string][]string{"us-east-1b": {"snap-1"}, "us-east-1a": {"snap-1"}},
+		}},
+	}
+
+	// The reused image is not owned, so only its fast restores are disabled
+	if err := artifact.Destroy(); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if !slices.Equal(actions, []string{"DisableFastSnapshotRestores us-east-1a", "DisableFastSnapshotRestores us-east-1b"}) {
+		t.Fatalf("unexpected requests: %v", actions)
+	}
+}
+
+func TestRoleConfig_AssumesRoleChain(t *testing.T) {
+	var requests []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing request: %v", err)
+		}
+		// Record the key each hop is signed with
+		r.Form.Set("SignedWith", strings.SplitN(strings.SplitN(r.Header.Get("Authorization"), "Credential=", 2)[1], "/", 2)[0])
+		requests = append(requests, r.Form)
+		key := "AKID" + strconv.Itoa(len(requests))
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+			<AccessKeyId>%s</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>
+			<Expiration>2030-01-01T00:00:00Z</Expiration>
+		</Credentials></AssumeRoleResult></AssumeRoleResponse>`, key)
+	}))
+	defer server.Close()
+
+	p := &PostProcessor{config: Config{
+		RoleName:        "ami-copy",
+		RoleExternalID:  "external",
+		RoleSessionName: "packer",
+		RoleDuration:    time.Hour,
+		RoleChain:       []string{"arn:aws:iam::999999999999:role/hub"},
+	}}
+	source := aws.Config{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("AKIDSOURCE", "secret", ""),
+	}
+
+	cfg := p.roleConfig(source, "111111111111")
+	creds, err := cfg.Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unable to retrieve credentials: %v", err)
+	}
+	if creds.AccessKeyID != "AKID2" {
+		t.Fatalf("expected the credentials of the last hop, got %s", creds.AccessKeyID)
+	}
+	if aws.ToString(cfg.BaseEndpoint) != server.URL {
+		t.Fatalf("expected the endpoint of the source config to be kept")
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 hops, got %d", len(requests))
+	}
+	hub, target := requests[0], requests[1]
+	if hub.Get("RoleArn") != "arn:aws:iam::999999999999:role/hub" || hub.Get("SignedWith") != "AKIDSOURCE" ||
+		hub.Get("ExternalId") != "" || hub.Get("RoleSessionName") != "packer" {
+		t.Fatalf("unexpected hub request: %v", hub)
+	}
+	if target.Get("RoleArn") != "arn:aws:iam::111111111111:role/ami-copy" || target.Get("SignedWith") != "AKID1" ||
+		target.Get("ExternalId") != "external" || target.Get("DurationSeconds") != "3600" || target.Get("RoleSessionName") != "packer" {
+		t.Fatalf("unexpected target request: %v", target)
+	}
+}
+
+func TestPostProcessorConfigure_RoleOptionsRequireRoleName(t *testing.T) {
+	p := &PostProcessor{}
+	err := p.Configure(map[string]any{
+		"ami_users":        []string{"111111111111"},
+		"role_external_id": "external",
+	})
+	if err == nil || !strings.Contains(err.Error(), "require role_name") {
+		t.Fatalf("expected role_name to be required, got: %v", err)
+	}
+
+	p = &PostProcessor{}
+	err = p.Configure(map[string]any{
+		"ami_users":     []string{"111111111111"},
+		"role_name":     "ami-copy",
+		"role_duration": "5m",
+	})
+	if err == nil || !strings.Contains(err.Error(), "at least 15m") {
+		t.Fatalf("expected role_duration to be validated, got: %v", err)
+	}
+}
+
+func TestPrepareKMS(t *testing.T) {
+	const key = "arn:aws:kms:us-east-1:222222222222:key/source"
+
+	// newServer fakes EC2 and KMS, answering DescribeKey with the key manager
+	newServer := func(t *testing.T, keyManager string, calls *[]string) aws.Config {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if target := r.Header.Get("X-Amz-Target"); target != "" {
+				var input map[string]any
+				if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+					t.Errorf("decoding request: %v", err)
+				}
+				*calls = append(*calls, strings.TrimPrefix(target, "TrentService."))
+				w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+				switch target {
+				case "TrentService.DescribeKey":
+					if keyManager == "" {
+						w.WriteHeader(http.StatusBadRequest)
+						fmt.Fprint(w, `{"__type":"AccessDeniedException","message":"denied"}`)
+						return
+					}
+					fmt.Fprintf(w, `{"KeyMetadata":{"KeyId":"source","KeyManager":"%s"}}`, keyManager)
+				case "TrentService.CreateGrant":
+					if input["GranteePrincipal"] != "arn:aws:iam::111111111111:root" || len(input["Operations"].([]any)) != len(kmsGrantOperations) {
+						t.Errorf("unexpected grant: %v", input)
+					}
+					fmt.Fprint(w, `{"GrantId":"grant-1"}`)
+				case "TrentService.RevokeGrant":
+					if input["GrantId"] != "grant-1" || input["KeyId"] != key {
+						t.Errorf("unexpected revoke: %v", input)
+					}
+					fmt.Fprint(w, `{}`)
+				}
+				return
+			}
+			if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "DescribeSnapshots" {
+				t.Errorf("unexpected request: %v", r.Form)
+			}
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprintf(w, `<DescribeSnapshotsResponse><snapshotSet>
+				<item><snapshotId>snap-1</snapshotId><encrypted>true</encrypted><kmsKeyId>%[1]s</kmsKeyId></item>
+				<item><snapshotId>snap-2</snapshotId><encrypted>true</encrypted><kmsKeyId>%[1]s</kmsKeyId></item>
+			</snapshotSet></DescribeSnapshotsResponse>`, key)
+		}))
+		t.Cleanup(server.Close)
+		return aws.Config{Region: "us-east-1", Credentials: aws.AnonymousCredentials{}, BaseEndpoint: aws.String(server.URL)}
+	}
+
+	newCopy := func(sourceCfg, targetCfg aws.Config, grants bool) *copyOperation {
+		return &copyOperation{
+			ctx:          context.Background(),
+			sourceConfig: sourceCfg,
+			targetConfig: targetCfg,
+			sourceImage: &types.Image{
+				OwnerId: aws.String("222222222222"),
+				BlockDeviceMappings: []types.BlockDeviceMapping{
+					{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-1")}},
+					{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-2")}},
+				},
+			},
+			sourceImageID:   "ami-src",
+			targetAccountID: "111111111111",
+			targetRegion:    "eu-west-1",
+			createKMSGrants: grants,
+		}
+	}
+
+	t.Run("grants and revokes", func(t *testing.T) {
+		ui := packersdk.TestUi(t)
+		var calls []string
+		c := newCopy(newServer(t, "CUSTOMER", &calls), aws.Config{}, true)
+		if err := c.prepareKMS(ui); err != nil {
+			t.Fatalf("prepareKMS failed: %v", err)
+		}
+		c.revokeKMSGrants(ui)
+		if !slices.Equal(calls, []string{"DescribeKey", "CreateGrant", "RevokeGrant"}) {
+			t.Fatalf("unexpected KMS calls: %v", calls)
+		}
+		if !slices.Equal(c.sourceKMSKeys, []string{key}) || c.kmsGrants != nil {
+			t.Fatalf("unexpected state: keys %v, grants %v", c.sourceKMSKeys, c.kmsGrants)
+		}
+	})
+
+	t.Run("rejects AWS managed keys", func(t *testing.T) {
+		var calls []string
+		c := newCopy(newServer(t, "AWS", &calls), aws.Config{}, true)
+		if err := c.prepareKMS(packersdk.TestUi(t)); err == nil || !strings.Contains(err.Error(), "AWS managed KMS key") {
+			t.Fatalf("expected the AWS managed key to be rejected, got: %v", err)
+		}
+	})
+
+	t.Run("checks the target can use the key", func(t *testing.T) {
+		var sourceCalls, targetCalls []string
+		c := newCopy(newServer(t, "CUSTOMER", &sourceCalls), newServer(t, "", &targetCalls), false)
+		err := c.prepareKMS(packersdk.TestUi(t))
+		if err == nil || !strings.Contains(err.Error(), "account 111111111111 cannot use KMS key "+key) {
+			t.Fatalf("expected the target to be denied, got: %v", err)
+		}
+		if !slices.Equal(targetCalls, []string{"DescribeKey"}) {
+			t.Fatalf("unexpected target calls: %v", targetCalls)
+		}
+		if err := c.kmsError(fmt.Errorf("copy failed")); !strings.Contains(err.Error(), "encrypted with KMS key "+key) {
+			t.Fatalf("expected copy failures to be attributed to KMS, got: %v", err)
+		}
+	})
+
+	t.Run("skips copies within the source account", func(t *testing.T) {
+		c := newCopy(aws.Config{}, aws.Config{}, true)
+		c.targetAccountID = "222222222222"
+		if err := c.prepareKMS(packersdk.TestUi(t)); err != nil || c.sourceKMSKeys != nil {
+			t.Fatalf("expected nothing to be done, got: %v", err)
+		}
+	})
+}
+
+func TestPlanCopies(t *testing.T) {
+	ui := &packersdk.MockUi{}
+
+	var copied []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing request: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		switch action := r.Form.Get("Action"); action {
+		case "GetCallerIdentity":
+			fmt.Fprint(w, `<GetCallerIdentityResponse><GetCallerIdentityResult><Account>111111111111</Account></GetCallerIdentityResult></GetCallerIdentityResponse>`)
+		case "DescribeImages":
+			images := ""
+			if r.Form.Get("Filter.1.Value.1") == "reused" {
+				images = `<item><imageId>ami-reused</imageId><imageState>available</imageState></item>`
+			}
+			fmt.Fprintf(w, `<DescribeImagesResponse><imagesSet>%s</imagesSet></DescribeImagesResponse>`, images)
+		case "CopyImage":
+			if r.Form.Get("DryRun") != "true" {
+				t.Errorf("expected a dry run copy: %v", r.Form)
+			}
+			copied = append(copied, r.Form.Get("Name"))
+			code, status := "DryRunOperation", http.StatusPreconditionFailed
+			if r.Form.Get("Name") == "denied" {
+				code, status = "UnauthorizedOperation", http.StatusForbidden
+			}
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `<Response><Errors><Error><Code>%s</Code><Message>%s</Message></Error></Errors><RequestID>1</RequestID></Response>`, code, code)
+		default:
+			t.Errorf("unexpected %s request", action)
+		}
+	}))
+	defer server.Close()
+
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}, BaseEndpoint: aws.String(server.URL)}
+	newCopy := func(name string) *copyOperation {
+		return &copyOperation{
+			ctx:             context.Background(),
+			client:          ec2.NewFromConfig(cfg),
+			targetConfig:    cfg,
+			sourceImage:     &types.Image{Name: aws.String(name)},
+			sourceRegion:    "us-east-1",
+			sourceImageID:   "ami-src",
+			targetRegion:    "eu-west-1",
+			targetAccountID: "111111111111",
+			planShare:       "111111111111: shared",
+		}
+	}
+	unshared := newCopy("unshared")
+	unshared.planShare, unshared.planUnshared = "111111111111: would share", true
+
+	err := planCopies(ui, []*copyOperation{newCopy("golden"), newCopy("reused"), newCopy("denied"), unshared})
+	if err == nil || !strings.Contains(err.Error(), "1/4 planned AMI copies would fail") {
+		t.Fatalf("expected the denied copy to fail the plan, got: %v", err)
+	}
+	if !slices.Equal(copied, []string{"golden", "denied"}) {
+		t.Fatalf("unexpected dry run copies: %v", copied)
+	}
+
+	plan := ui.SayMessages[0].Message
+	for _, want := range []string{
+		"111111111111  eu-west-1  us-east-1:ami-src  111111111111: shared",
+		"copy as golden",
+		"reuse ami-reused (reused)",
+		"111111111111: would share  copy as unshared",
+		"unvalidated (not yet shared)",
+		"error: operation error EC2: CopyImage",
+	} {
+		if !strings.Contains(plan, want) {
+			t.Fatalf("expected %q in the plan:\n%s", want, plan)
+		}
+	}
+}
+
+func TestCopyOperations_ReleasesSharesOnError(t *testing.T) {
+	const orgArn = "arn:aws:organizations::123456789012:organization/o-abc"
+
+	var modified []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing request: %v", err)
+		}
+		action := r.Form.Get("Action")
+		var body string
+		switch action {
+		case "DescribeImages":
+			// ami-2 is not found
+			if r.Form.Get("Filter.1.Value.1") == "ami-1" {
+				body = `<imagesSet><item><imageId>ami-1</imageId></item></imagesSet>`
+			} else {
+				body = `<imagesSet/>`
+			}
+		case "DescribeImageAttribute":
+			body = `<imageId>ami-1</imageId><launchPermission/>`
+		case "ModifyImageAttribute":
+			for k, v := range r.Form {
+				if strings.HasPrefix(k, "LaunchPermission.") {
+					modified = append(modified, strings.Split(k, ".")[1]+" "+v[0])
+				}
+			}
+			body = "<return>true</return>"
+		default:
+			t.Errorf("unexpected %s request", action)
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>test</requestId>%[2]s</%[1]sResponse>`, action, body)
+	}))
+	defer server.Close()
+	cfg := aws.Config{Region: "us-east-1", Credentials: aws.AnonymousCredentials{}, BaseEndpoint: aws.String(server.URL)}
+
+	p := &PostProcessor{}
+	p.config.AMIUsers = []string{"111111111111"}
+	p.config.AMIOrgArns = []string{orgArn}
+	p.config.RevokeShareAfterCopy = true
+
+	amis := []*helpers.AMI{{Region: "us-east-1", ID: "ami-1"}, {Region: "us-east-1", ID: "ami-2"}}
+	if _, err := p.copyOperations(context.Background(), packersdk.TestUi(t), cfg, amis); err == nil {
+		t.Fatal("expected ami-2 not to be found")
+	}
+	if !slices.Equal(modified, []string{"Add " + orgArn, "Remove " + orgArn}) {
+		t.Fatalf("expected the share of ami-1 to be revoked, got %v", modified)
+	}
+}
+
+func TestSourceConfig(t *testing.T) {
+	awsCfg := aws.Config{Region: "us-east-1", AppID: "caller"}
+	configs := map[string]aws.Config{
+		"111111111111": awsCfg,
+		"222222222222": {Region: "us-east-1", AppID: "target"},
+	}
+
+	p := &PostProcessor{}
+	for _, tt := range []struct {
+		ami   helpers.AMI
+		appID string
+	}{
+		{ami: helpers.AMI{Region: "eu-west-1", ID: "ami-1"}, appID: "caller"},
+		{ami: helpers.AMI{AccountID: "111111111111", Region: "eu-west-1", ID: "ami-1"}, appID: "caller"},
+		{ami: helpers.AMI{AccountID: "222222222222", Region: "eu-west-1", ID: "ami-1"}, appID: "target"},
+	} {
+		cfg, err := p.sourceConfig(awsCfg, configs, &tt.ami)
+		if err != nil || cfg.AppID != tt.appID || cfg.Region != "eu-west-1" {
+			t.Fatalf("expected the %s config in eu-west-1 for %+v, got %s in %s (%v)", tt.appID, tt.ami, cfg.AppID, cfg.Region, err)
+		}
+	}
+
+	other := &helpers.AMI{AccountID: "333333333333", Region: "eu-west-1", ID: "ami-1"}
+	if _, err := p.sourceConfig(awsCfg, configs, other); err == nil || !strings.Contains(err.Error(), "no credentials for account 333333333333") {
+		t.Fatalf("expected missing credentials to be reported, got %v", err)
+	}
+
+	p.config.RoleName = "copy"
+	cfg, err := p.sourceConfig(awsCfg, configs, other)
+	if err != nil || cfg.Credentials == nil || cfg.Region != "eu-west-1" {
+		t.Fatalf("expected the credentials of role_name, got %+v (%v)", cfg, err)
+	}
+}
+
+func TestReleaseShares_KeepsHeldShares(t *testing.T) {
+	var modified []string
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		switch action {
+		case "ModifyImageAttribute", "ModifySnapshotAttribute":
+			modified = append(modified, action)
+			return "<return>true</return>"
+		default:
+			t.Errorf("unexpected %s request", action)
+			return ""
+		}
+	})
+
+	ui := packersdk.TestUi(t)
+	s := newSourceShare(&helpers.ImageShare{
+		ImageID:     "ami-src",
+		Permission:  types.LaunchPermission{UserId: aws.String("111111111111")},
+		SnapshotIDs: []string{"snap-2"},
+	}, client)
+	first, second := &copyOperation{}, &copyOperation{}
+	first.holdShares([]*sourceShare{s})
+	second.holdShares([]*sourceShare{s})
+	releaseShares(context.Background(), ui, []*sourceShare{s})
+	releaseShares(context.Background(), ui, first.shares)
+	if len(modified) != 0 {
+		t.Fatalf("expected the share to be kept while a copy holds it, got %v", modified)
+	}
+	releaseShares(context.Background(), ui, second.shares)
+
+	if !slices.Equal(modified, []string{"ModifyImageAttribute", "ModifySnapshotAttribute"}) {
+		t.Fatalf("unexpected modifications: %v", modified)
+	}
+}
+
+func TestCopyExecute_ResumesFromStateFile(t *testing.T) {
+	ui := packersdk.TestUi(t)
+	path := t.TempDir() + "/state.json"
+
+	state, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loading a missing state file failed: %v", err)
+	}
+	for _, m := range []*AmiManifest{
+		{AccountID: "111111111111", Region: "us-east-1", SourceImageID: "ami-a", ImageID: "ami-done", Status: StatusCopied},
+		{AccountID: "111111111111", Region: "us-east-1", SourceImageID: "ami-b", ImageID: "ami-pending", Status: StatusInProgress},
+		{AccountID: "111111111111", Region: "us-east-1", SourceImageID: "ami-c", ImageID: "ami-foreign", Status: StatusCopied},
+	} {
+		if err := state.record(m); err != nil {
+			t.Fatalf("recording state failed: %v", err)
+		}
+	}
+	if state, err = loadState(path); err != nil || len(state.copies) != 3 {
+		t.Fatalf("expected 3 recorded copies, got %v (err: %v)", state, err)
+	}
+
+	var tagged []string
+	client := newTestEC2Client(t, func(action string, r *http.Request) string {
+		switch action {
+		case "DescribeImages":
+			// Recorded copies are looked up by ID, waits filter on it
+			id := r.Form.Get("ImageId.1")
+			if id != "" && r.Form.Get("Owner.1") != "self" {
+				t.Errorf("expected the recorded copy to be looked up in the target account: %v", r.Form)
+			} else if id == "" {
+				id = r.Form.Get("Filter.1.Value.1")
+			}
+			switch id {
+			case "ami-done":
+				return `<imagesSet><item><imageId>ami-done</imageId><name>done</name><imageState>available</imageState><sourceImageId>ami-a</sourceImageId></item></imagesSet>`
+			case "ami-pending":
+				return `<imagesSet><item><imageId>ami-pending</imageId><name>pending</name><imageState>pending</imageState>` +
+					`<tagSet><item><key>` + SourceAMITag + `</key><value>ami-b</value></item></tagSet></item></imagesSet>`
+			case "ami-foreign":
+				return `<imagesSet><item><imageId>ami-foreign</imageId><name>foreign</name><imageState>available</imageState><sourceImageId>ami-other</sourceImageId></item></imagesSet>`
+			}
+			t.Errorf("unexpected DescribeImages request: %v", r.Form)
+			return "<imagesSet/>"
+		case "CreateTags":
+			tagged = append(tagged, r.Form.Get("ResourceId.1"))
+			return "<return>true</return>"
+		default:
+			t.Errorf("unexpected %s request", action)
+			return ""
+		}
+	})
+
+	newCopy := func(source string) *copyOperation {
+		return &copyOperation{
+			ctx:             context.Background(),
+			client:          client,
+			sourceImage:     &types.Image{ImageId: aws.String(source), Name: aws.String("my-image")},
+			sourceRegion:    "us-east-1",
+			sourceImageID:   source,
+			targetRegion:    "us-east-1",
+			targetAccountID: "111111111111",
+			state:           state,
+		}
+	}
+
+	done := newCopy("ami-a")
+	if err := done.execute(ui); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	if m := done.manifest(nil); m.ImageID != "ami-done" || m.Status != StatusSkipped {
+		t.Fatalf("expected ami-done to be skipped, got %s (%s)", m.ImageID, m.Status)
+	}
+
+	pending := newCopy("ami-b")
+	if err := pending.execute(ui); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	if m := pending.manifest(nil); m.ImageID != "ami-pending" || m.Status != StatusResumed {
+		t.Fatalf("expected ami-pending to be resumed, got %s (%s)", m.ImageID, m.Status)
+	}
+	if !slices.Equal(tagged, []string{"ami-pending"}) {
+		t.Fatalf("expected only the resumed copy to be tagged, got %v", tagged)
+	}
+
+	// A recorded image that is not a copy of the source is not reused
+	foreign := newCopy("ami-c")
+	if foreign.resume(ui) || foreign.reused || foreign.copiedImageID != "" {
+		t.Fatalf("expected ami-foreign not to be resumed, got %s", foreign.copiedImageID)
+	}
+}
@@ -0,0 +1,205 @@
+package ami_copy
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"github.com/bdwyertech/packer-plugin-aws/helpers"
+)
+
+// fastRestoreEnabled reports whether fast snapshot restore is wanted for the
+// copy, in every availability zone of its region or in the listed ones.
+func (c *copyOperation) fastRestoreEnabled() bool {
+	return !c.tagsOnly && (c.fastLaunch || len(c.fastRestoreZones()) > 0)
+}
+
+// fastRestoreZones returns the zones of `fast_snapshot_restore_azs` in the
+// region of the copy.
+func (c *copyOperation) fastRestoreZones() []string {
+	var zones []string
+	for _, zone := range c.fastRestoreAZs {
+		if strings.HasPrefix(zone, c.targetRegion) {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// enableFastRestore enables fast snapshot restore on the snapshots of the
+// available copy and waits for it to be enabled in every zone. Only the zones
+// and snapshots that were not enabled already are recorded in fastRestores,
+// so that destroying the artifact does not disable restores it did not enable.
+func (c *copyOperation) enableFastRestore(ui packer.Ui) error {
+	image, err := helpers.LocateSingleAMI(c.ctx, c.copiedImageID, c.client)
+	if err != nil {
+		return err
+	}
+	snapshots := imageSnapshotIDs(image)
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	zones := c.fastRestoreZones()
+	if len(zones) == 0 {
+		output, err := c.client.DescribeAvailabilityZones(c.ctx, &ec2.DescribeAvailabilityZonesInput{
+			Filters: []types.Filter{
+				{Name: aws.String("zone-type"), Values: []string{"availability-zone"}},
+				{Name: aws.String("state"), Values: []string{"available"}},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to list availability zones of %s in account %s: %w", c.targetRegion, c.targetAccountID, err)
+		}
+		for _, zone := range output.AvailabilityZones {
+			zones = append(zones, aws.ToString(zone.ZoneName))
+		}
+	}
+
+	states, err := c.fastRestoreStates(c.ctx, snapshots)
+	if err != nil {
+		return err
+	}
+
+	c.fastRestores = map[string][]string{}
+	for _, zone := range zones {
+		var missing []string
+		for _, snapshot := range snapshots {
+			switch states[snapshot+"|"+zone].State {
+			case types.FastSnapshotRestoreStateCodeEnabling,
+				types.FastSnapshotRestoreStateCodeOptimizing,
+				types.FastSnapshotRestoreStateCodeEnabled:
+			default:
+				missing = append(missing, snapshot)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		ui.Say(fmt.Sprintf("Enabling fast snapshot restore of %v in %s on account %s", missing, zone, c.targetAccountID))
+		output, err := c.client.EnableFastSnapshotRestores(c.ctx, &ec2.EnableFastSnapshotRestoresInput{
+			AvailabilityZones: []string{zone},
+			SourceSnapshotIds: missing,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to enable fast snapshot restore in %s: %w", zone, err)
+		}
+		for _, item := range output.Unsuccessful {
+			for _, e := range item.FastSnapshotRestoreStateErrors {
+				if e.Error != nil {
+					return fmt.Errorf("unable to enable fast snapshot restore of %s in %s: %s",
+						aws.ToString(item.SnapshotId), zone, aws.ToString(e.Error.Message))
+				}
+			}
+		}
+		c.fastRestores[zone] = missing
+	}
+	c.fastRestoredAZs = zones
+
+	return c.waitForFastRestore(ui, snapshots, zones)
+}
+
+// waitForFastRestore waits for fast snapshot restore of every snapshot to be
+// enabled in every zone. It gives up after the copy timeout or when the
+// context is cancelled.
+func (c *copyOperation) waitForFastRestore(ui packer.Ui, snapshots, zones []string) error {
+	ui.Say(fmt.Sprintf("Waiting up to %s for fast snapshot restore of %s to be enabled on account %s", c.copyTimeout, c.copiedImageID, c.targetAccountID))
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.copyTimeout)
+	defer cancel()
+
+	for {
+		states, err := c.fastRestoreStates(ctx, snapshots)
+		if err == nil {
+			var pending []string
+			for _, zone := range zones {
+				for _, snapshot := range snapshots {
+					item := states[snapshot+"|"+zone]
+					switch item.State {
+					case types.FastSnapshotRestoreStateCodeEnabled:
+						continue
+					case types.FastSnapshotRestoreStateCodeDisabling, types.FastSnapshotRestoreStateCodeDisabled:
+						return fmt.Errorf("fast snapshot restore of %s in %s was %s: %s",
+							snapshot, zone, item.State, aws.ToString(item.StateTransitionReason))
+					}
+					pending = append(pending, fmt.Sprintf("%s %s %s", snapshot, zone, item.State))
+				}
+			}
+			if len(pending) == 0 {
+				return nil
+			}
+			ui.Say(fmt.Sprintf("Fast snapshot restore of %s on account %s: %s", c.copiedImageID, c.targetAccountID, strings.Join(pending, ", ")))
+		}
+
+		select {
+		case <-ctx.Done():
+			if c.ctx.Err() != nil {
+				return fmt.Errorf("cancelled waiting for fast snapshot restore of %s on account %s: %w", c.copiedImageID, c.targetAccountID, c.ctx.Err())
+			}
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("timed out after %s waiting for fast snapshot restore of %s on account %s", c.copyTimeout, c.copiedImageID, c.targetAccountID)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// fastRestoreStates returns the fast snapshot restore state of the snapshots,
+// keyed by `snapshot|zone`.
+func (c *copyOperation) fastRestoreStates(ctx context.Context, snapshots []string) (map[string]types.DescribeFastSnapshotRestoreSuccessItem, error) {
+	states := map[string]types.DescribeFastSnapshotRestoreSuccessItem{}
+	paginator := ec2.NewDescribeFastSnapshotRestoresPaginator(c.client, &ec2.DescribeFastSnapshotRestoresInput{
+		Filters: []types.Filter{
+			{Name: aws.String("snapshot-id"), Values: snapshots},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe fast snapshot restores on account %s: %w", c.targetAccountID, err)
+		}
+		for _, item := range page.FastSnapshotRestores {
+			states[aws.ToString(item.SnapshotId)+"|"+aws.ToString(item.AvailabilityZone)] = item
+		}
+	}
+	return states, nil
+}
+
+// disableFastRestores disables the fast snapshot restores enabled for an
+// image, given as snapshots by zone.
+func disableFastRestores(ctx context.Context, client *ec2.Client, restores map[string][]string) error {
+	zones := slices.Sorted(maps.Keys(restores))
+	var errs *packer.MultiError
+	for _, zone := range zones {
+		output, err := client.DisableFastSnapshotRestores(ctx, &ec2.DisableFastSnapshotRestoresInput{
+			AvailabilityZones: []string{zone},
+			SourceSnapshotIds: restores[zone],
+		})
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("unable to disable fast snapshot restore in %s: %w", zone, err))
+			continue
+		}
+		for _, item := range output.Unsuccessful {
+			for _, e := range item.FastSnapshotRestoreStateErrors {
+				if e.Error != nil {
+					errs = packer.MultiErrorAppend(errs, fmt.Errorf("unable to disable fast snapshot restore of %s in %s: %s",
+						aws.ToString(item.SnapshotId), zone, aws.ToString(e.Error.Message)))
+				}
+			}
+		}
+	}
+	if errs != nil && len(errs.Errors) != 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package ami_copy
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// kmsGrantOperations are the operations EBS needs on the key of a source
+// snapshot to copy it into another account.
+var kmsGrantOperations = []kmstypes.GrantOperation{
+	kmstypes.GrantOperationDecrypt,
+	kmstypes.GrantOperationDescribeKey,
+	kmstypes.GrantOperationCreateGrant,
+	kmstypes.GrantOperationGenerateDataKeyWithoutPlaintext,
+	kmstypes.GrantOperationReEncryptFrom,
+}
+
+// kmsGrant is a grant created on a source key for the target account.
+type kmsGrant struct {
+	keyID   string
+	grantID string
+}
+
+// prepareKMS looks up the KMS keys of the source snapshots before a copy into
+// another account. AWS managed keys cannot be used by other accounts and fail
+// the copy. With `create_kms_grants`, the target account is granted the use
+// of customer managed keys until the copy is available, otherwise it must
+// already be allowed to use them.
+func (c *copyOperation) prepareKMS(ui packer.Ui) error {
+	if aws.ToString(c.sourceImage.OwnerId) == c.targetAccountID {
+		return nil
+	}
+
+	keys, err := c.sourceKMSKeyIDs()
+	if err != nil {
+		return fmt.Errorf("unable to look up the KMS keys of %s: %w", c.sourceImageID, err)
+	}
+	c.sourceKMSKeys = keys
+
+	for _, key := range keys {
+		output, err := kms.NewFromConfig(kmsConfig(c.sourceConfig, key)).DescribeKey(c.ctx, &kms.DescribeKeyInput{
+			KeyId: aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to describe KMS key %s of %s: %w", key, c.sourceImageID, err)
+		}
+		if output.KeyMetadata.KeyManager == kmstypes.KeyManagerTypeAws {
+			return fmt.Errorf("%s is encrypted with the AWS managed KMS key %s, which account %s cannot use: re-encrypt it with a customer managed key",
+				c.sourceImageID, key, c.targetAccountID)
+		}
+
+		if c.createKMSGrants {
+			if err := c.createKMSGrant(ui, key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// The target account needs the key to copy, check that it can use it
+		if _, err := kms.NewFromConfig(kmsConfig(c.targetConfig, key)).DescribeKey(c.ctx, &kms.DescribeKeyInput{
+			KeyId: aws.String(key),
+		}); err != nil {
+			return fmt.Errorf("account %s cannot use KMS key %s of %s, allow it kms:DescribeKey, kms:CreateGrant and kms:Decrypt on the key or set create_kms_grants: %w",
+				c.targetAccountID, key, c.sourceImageID, err)
+		}
+	}
+
+	return nil
+}
+
+// createKMSGrant grants the target account the use of a source key.
+func (c *copyOperation) createKMSGrant(ui packer.Ui, key string) error {
+	partition := "aws"
+	if parsed, err := arn.Parse(key); err == nil {
+		partition = parsed.Partition
+	}
+	grantee := fmt.Sprintf("arn:%s:iam::%s:root", partition, c.targetAccountID)
+
+	ui.Say(fmt.Sprintf("Granting account %s the use of KMS key %s", c.targetAccountID, key))
+	output, err := kms.NewFromConfig(kmsConfig(c.sourceConfig, key)).CreateGrant(c.ctx, &kms.CreateGrantInput{
+		KeyId:            aws.String(key),
+		GranteePrincipal: aws.String(grantee),
+		Operations:       kmsGrantOperations,
+		Name:             aws.String(fmt.Sprintf("packer-ami-copy-%s-%s-%s", c.sourceImageID, c.targetAccountID, c.targetRegion)),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to grant account %s the use of KMS key %s: %w", c.targetAccountID, key, err)
+	}
+	c.kmsGrants = append(c.kmsGrants, kmsGrant{keyID: key, grantID: aws.ToString(output.GrantId)})
+	return nil
+}
+
+// revokeKMSGrants revokes the grants created for the copy. Failures are only
+// reported, as the copy itself is done.
+func (c *copyOperation) revokeKMSGrants(ui packer.Ui) {
+	for _, grant := range c.kmsGrants {
+		ui.Say(fmt.Sprintf("Revoking KMS grant %s on %s for account %s", grant.grantID, grant.keyID, c.targetAccountID))
+		if _, err := kms.NewFromConfig(kmsConfig(c.sourceConfig, grant.keyID)).RevokeGrant(c.ctx, &kms.RevokeGrantInput{
+			KeyId:   aws.String(grant.keyID),
+			GrantId: aws.String(grant.grantID),
+		}); err != nil {
+			ui.Error(fmt.Sprintf("Unable to revoke KMS grant %s on %s, revoke it manually: %s", grant.grantID, grant.keyID, err))
+		}
+	}
+	c.kmsGrants = nil
+}
+
+// sourceKMSKeyIDs returns the KMS keys encrypting the snapshots of the source
+// image, described with the source credentials.
+func (c *copyOperation) sourceKMSKeyIDs() ([]string, error) {
+	ids := imageSnapshotIDs(c.sourceImage)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	output, err := ec2.NewFromConfig(c.sourceConfig).DescribeSnapshots(c.ctx, &ec2.DescribeSnapshotsInput{
+		SnapshotIds: ids,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, snapshot := range output.Snapshots {
+		key := aws.ToString(snapshot.KmsKeyId)
+		if aws.ToBool(snapshot.Encrypted) && key != "" && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// kmsError attributes a failed copy to the KMS keys of the source snapshots,
+// the usual cause of copies into other accounts failing.
+func (c *copyOperation) kmsError(err error) error {
+	if len(c.sourceKMSKeys) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w (the source snapshots are encrypted with KMS key %s, check that account %s is allowed to use it)",
+		err, strings.Join(c.sourceKMSKeys, ", "), c.targetAccountID)
+}
+
+// kmsConfig returns the config to call KMS about a key, in the region of the
+// key when given as an ARN.
+func kmsConfig(cfg aws.Config, key string) aws.Config {
+	cfg = cfg.Copy()
+	if parsed, err := arn.Parse(key); err == nil && parsed.Region != "" {
+		cfg.Region = parsed.Region
+	}
+	return cfg
+}
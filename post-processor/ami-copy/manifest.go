@@ -0,0 +1,147 @@
+package ami_copy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestVersion is the version of the manifest schema written to `manifest_output`.
+const ManifestVersion = 1
+
+// Copy statuses reported in the manifest.
+const (
+	StatusCopied = "copied"
+	StatusReused = "reused"
+	StatusFailed = "failed"
+	// Copies picked up from `state_file`: resumed copies were still in
+	// progress and were waited for, skipped ones were finished already.
+	StatusResumed = "resumed"
+	StatusSkipped = "skipped"
+	// Only recorded in `state_file`, for copies not finished yet.
+	StatusInProgress = "in_progress"
+)
+
+// Manifest formats supported by `manifest_format`.
+const (
+	ManifestFormatJSON = "json"
+	ManifestFormatYAML = "yaml"
+)
+
+// Manifest is the document written to `manifest_output`.
+type Manifest struct {
+	Version int            `json:"version" yaml:"version"`
+	Copies  []*AmiManifest `json:"copies" yaml:"copies"`
+	// Older copies removed by the retention settings.
+	Pruned []*PrunedManifest `json:"pruned,omitempty" yaml:"pruned,omitempty"`
+}
+
+// AmiManifest holds the data about the resulting copied image
+type AmiManifest struct {
+	AccountID     string    `json:"account_id" yaml:"account_id"`
+	Region        string    `json:"region" yaml:"region"`
+	ImageID       string    `json:"image_id,omitempty" yaml:"image_id,omitempty"`
+	SourceImageID string    `json:"source_image_id" yaml:"source_image_id"`
+	SourceRegion  string    `json:"source_region" yaml:"source_region"`
+	Name          string    `json:"name,omitempty" yaml:"name,omitempty"`
+	Encrypted     bool      `json:"encrypted" yaml:"encrypted"`
+	KmsKeyID      string    `json:"kms_key_id,omitempty" yaml:"kms_key_id,omitempty"`
+	SnapshotIDs   []string  `json:"snapshot_ids,omitempty" yaml:"snapshot_ids,omitempty"`
+	SSMParameter  string    `json:"ssm_parameter,omitempty" yaml:"ssm_parameter,omitempty"`
+	Status        string    `json:"status" yaml:"status"`
+	Error         string    `json:"error,omitempty" yaml:"error,omitempty"`
+	StartTime     time.Time `json:"start_time" yaml:"start_time"`
+	EndTime       time.Time `json:"end_time" yaml:"end_time"`
+
+	// The availability zones fast snapshot restore is enabled in.
+	FastSnapshotRestoreAZs []string `json:"fast_snapshot_restore_azs,omitempty" yaml:"fast_snapshot_restore_azs,omitempty"`
+}
+
+func writeManifests(output, format string, manifest *Manifest) error {
+	var rawManifest []byte
+	var err error
+	switch format {
+	case ManifestFormatYAML:
+		rawManifest, err = yaml.Marshal(manifest)
+	default:
+		rawManifest, err = json.MarshalIndent(manifest, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, rawManifest, 0644)
+}
+
+// ReadManifest reads a manifest written to `manifest_output`, in either
+// format.
+func ReadManifest(path string) (*Manifest, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if json.Valid(raw) {
+		err = json.Unmarshal(raw, &manifest)
+	} else {
+		err = yaml.Unmarshal(raw, &manifest)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return &manifest, nil
+}
+
+// packerManifest mirrors the file written by Packer's `manifest` post-processor.
+type packerManifest struct {
+	Builds      []packerManifestBuild `json:"builds"`
+	LastRunUUID string                `json:"last_run_uuid"`
+}
+
+type packerManifestBuild struct {
+	Name          string            `json:"name"`
+	BuilderType   string            `json:"builder_type"`
+	BuildTime     int64             `json:"build_time"`
+	Files         []any             `json:"files"`
+	ArtifactId    string            `json:"artifact_id"`
+	PackerRunUUID string            `json:"packer_run_uuid"`
+	CustomData    map[string]string `json:"custom_data"`
+}
+
+// appendPackerManifest adds a build for the copied AMIs to a manifest in the
+// format of Packer's `manifest` post-processor, creating it if needed.
+func appendPackerManifest(output, name string, artifact *Artifact) error {
+	var manifest packerManifest
+	raw, err := os.ReadFile(output)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(raw, &manifest); err != nil {
+			return fmt.Errorf("failed to parse Packer manifest %s: %w", output, err)
+		}
+	}
+
+	runUUID := os.Getenv("PACKER_RUN_UUID")
+	manifest.Builds = append(manifest.Builds, packerManifestBuild{
+		Name:          name,
+		BuilderType:   "ami-copy",
+		BuildTime:     time.Now().Unix(),
+		ArtifactId:    artifact.Id(),
+		PackerRunUUID: runUUID,
+		CustomData:    map[string]string{},
+	})
+	manifest.LastRunUUID = runUUID
+
+	raw, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, raw, 0664)
+}
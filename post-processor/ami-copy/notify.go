@@ -0,0 +1,157 @@
+//go:generate packer-sdc struct-markdown
+
+package ami_copy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// NotificationConfig publishes a message for every finished copy and a summary
+// once all copies are done, to either an SNS topic or an SQS queue.
+type NotificationConfig struct {
+	// The ARN of the SNS topic to publish to.
+	SNSTopicArn string `mapstructure:"sns_topic_arn"`
+	// The URL of the SQS queue to send to.
+	SQSQueueURL string `mapstructure:"sqs_queue_url"`
+	// Send the message of each copy with the credentials of its target
+	// account rather than the source credentials. The summary is always sent
+	// with the source credentials.
+	UseTargetCredentials bool `mapstructure:"use_target_credentials"`
+}
+
+// Prepare validates the notification settings.
+func (n *NotificationConfig) Prepare() (errs []error) {
+	if (n.SNSTopicArn == "") == (n.SQSQueueURL == "") {
+		errs = append(errs, fmt.Errorf("notification requires exactly one of sns_topic_arn or sqs_queue_url"))
+	}
+	if n.SNSTopicArn != "" {
+		if _, err := arn.Parse(n.SNSTopicArn); err != nil {
+			errs = append(errs, fmt.Errorf("notification sns_topic_arn is invalid: %w", err))
+		}
+	}
+	if n.SQSQueueURL != "" {
+		if _, err := url.ParseRequestURI(n.SQSQueueURL); err != nil {
+			errs = append(errs, fmt.Errorf("notification sqs_queue_url is invalid: %w", err))
+		}
+	}
+	return errs
+}
+
+// Notification events.
+const (
+	EventCopy    = "copy"
+	EventSummary = "summary"
+)
+
+// Notification is the message body published for each event.
+type Notification struct {
+	Event string `json:"event"`
+	// The manifest entry of a finished copy.
+	Copy *AmiManifest `json:"copy,omitempty"`
+	// The manifest of the whole run.
+	Summary *Manifest `json:"summary,omitempty"`
+}
+
+// notifier delivers notifications to the configured topics and queues.
+type notifier struct {
+	configs []NotificationConfig
+	source  aws.Config
+}
+
+// notifyCopy publishes the manifest entry of a finished copy.
+func (n *notifier) notifyCopy(ctx context.Context, ui packer.Ui, c *copyOperation, m *AmiManifest) {
+	for _, config := range n.configs {
+		cfg := n.source
+		if config.UseTargetCredentials {
+			cfg = c.targetConfig
+		}
+		if err := config.send(ctx, cfg, &Notification{Event: EventCopy, Copy: m}, m.Status); err != nil {
+			ui.Error(fmt.Sprintf("Unable to send notification for %s in account %s: %s", m.SourceImageID, m.AccountID, err))
+		}
+	}
+}
+
+// notifySummary publishes the manifest of the whole run.
+func (n *notifier) notifySummary(ctx context.Context, ui packer.Ui, manifest *Manifest) {
+	status := StatusCopied
+	for _, m := range manifest.Copies {
+		if m.Status == StatusFailed {
+			status = StatusFailed
+		}
+	}
+	for _, config := range n.configs {
+		if err := config.send(ctx, n.source, &Notification{Event: EventSummary, Summary: manifest}, status); err != nil {
+			ui.Error(fmt.Sprintf("Unable to send summary notification: %s", err))
+		}
+	}
+}
+
+// send delivers the notification. The event and status are set as message
+// attributes so that subscribers can filter on them.
+func (n *NotificationConfig) send(ctx context.Context, cfg aws.Config, notification *Notification, status string) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	cfg = cfg.Copy()
+	switch {
+	case n.SNSTopicArn != "":
+		topic, err := arn.Parse(n.SNSTopicArn)
+		if err != nil {
+			return err
+		}
+		cfg.Region = topic.Region
+		_, err = sns.NewFromConfig(cfg).Publish(ctx, &sns.PublishInput{
+			TopicArn: aws.String(n.SNSTopicArn),
+			Message:  aws.String(string(body)),
+			MessageAttributes: map[string]snstypes.MessageAttributeValue{
+				"event":  {DataType: aws.String("String"), StringValue: aws.String(notification.Event)},
+				"status": {DataType: aws.String("String"), StringValue: aws.String(status)},
+			},
+		})
+		return err
+	case n.SQSQueueURL != "":
+		if region := sqsQueueRegion(n.SQSQueueURL); region != "" {
+			cfg.Region = region
+		}
+		_, err = sqs.NewFromConfig(cfg).SendMessage(ctx, &sqs.SendMessageInput{
+			QueueUrl:    aws.String(n.SQSQueueURL),
+			MessageBody: aws.String(string(body)),
+			MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+				"event":  {DataType: aws.String("String"), StringValue: aws.String(notification.Event)},
+				"status": {DataType: aws.String("String"), StringValue: aws.String(status)},
+			},
+		})
+		return err
+	default:
+		return errors.New("no topic or queue configured")
+	}
+}
+
+// sqsQueueRegion returns the region of a queue URL such as
+// https://sqs.us-east-1.amazonaws.com/123456789012/queue.
+func sqsQueueRegion(queueURL string) string {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(u.Hostname(), ".")
+	if len(parts) < 3 || parts[0] != "sqs" {
+		return ""
+	}
+	return parts[1]
+}
@@ -0,0 +1,178 @@
+package ami_copy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"github.com/bdwyertech/packer-plugin-aws/helpers"
+)
+
+// planEntry is a row of the dry run plan.
+type planEntry struct {
+	accountID string
+	region    string
+	source    string
+	share     string
+	action    string
+	check     string
+	failed    bool
+}
+
+// planCopies prints the planned copies without making any change. Each copy
+// is validated with read-only and dry run calls in its target account, and an
+// error is returned if any of them would fail.
+func planCopies(ui packer.Ui, copies []*copyOperation) error {
+	var entries []*planEntry
+	failed := 0
+	for _, c := range copies {
+		entry := c.plan()
+		if entry.failed {
+			failed++
+		}
+		entries = append(entries, entry)
+	}
+
+	ui.Say(fmt.Sprintf("Dry run, planned copies:\n%s", formatPlan(entries)))
+
+	if failed > 0 {
+		return fmt.Errorf("%d/%d planned AMI copies would fail", failed, len(copies))
+	}
+	return nil
+}
+
+// plan resolves what execute would do for the copy and validates it.
+func (c *copyOperation) plan() *planEntry {
+	entry := &planEntry{
+		accountID: c.targetAccountID,
+		region:    c.targetRegion,
+		source:    fmt.Sprintf("%s:%s", c.sourceRegion, c.sourceImageID),
+		share:     c.planShare,
+		check:     "ok",
+	}
+	fail := func(err error) *planEntry {
+		entry.check = "error: " + err.Error()
+		entry.failed = true
+		return entry
+	}
+
+	// Validate the target credentials
+	identity, err := sts.NewFromConfig(c.targetConfig).GetCallerIdentity(c.ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		entry.action = "copy"
+		return fail(fmt.Errorf("unable to resolve the target account: %w", err))
+	}
+	if account := aws.ToString(identity.Account); account != c.targetAccountID {
+		entry.action = "copy"
+		return fail(fmt.Errorf("target credentials resolve to account %s", account))
+	}
+
+	name, err := c.render(c.nameTemplate, aws.ToString(c.sourceImage.Name))
+	if err != nil {
+		return fail(fmt.Errorf("unable to render AMI name: %w", err))
+	}
+	description, err := c.render(c.descTemplate, aws.ToString(c.sourceImage.Description))
+	if err != nil {
+		return fail(fmt.Errorf("unable to render AMI description: %w", err))
+	}
+	tags, err := c.imageTags()
+	if err != nil {
+		return fail(err)
+	}
+	snapshotTags, err := c.renderTags(c.snapshotTags)
+	if err != nil {
+		return fail(err)
+	}
+
+	if c.tagsOnly {
+		entry.action = "tag " + c.sourceImageID
+		_, err := c.client.CreateTags(c.ctx, &ec2.CreateTagsInput{
+			Resources: []string{c.sourceImageID},
+			Tags:      tags,
+			DryRun:    aws.Bool(true),
+		})
+		if !isDryRunOperation(err) {
+			return fail(err)
+		}
+		return entry
+	}
+
+	if c.requireBlockPublicAccess {
+		if err := c.checkBlockPublicAccess(); err != nil {
+			return fail(err)
+		}
+	}
+
+	existing, err := c.findExistingCopy(name)
+	if err != nil {
+		return fail(err)
+	}
+	if existing != nil {
+		entry.action = fmt.Sprintf("reuse %s (%s)", aws.ToString(existing.ImageId), name)
+		return entry
+	}
+
+	entry.action = fmt.Sprintf("copy as %s", name)
+	if c.encrypted {
+		entry.action += " (encrypted)"
+	}
+	// The copy cannot be validated before the source is shared
+	if c.planUnshared {
+		entry.check = "unvalidated (not yet shared)"
+		return entry
+	}
+
+	input := c.copyImageInput(name, description, tags, snapshotTags)
+	input.DryRun = aws.Bool(true)
+	if _, err := c.client.CopyImage(c.ctx, input); !isDryRunOperation(err) {
+		return fail(err)
+	}
+
+	return entry
+}
+
+// isDryRunOperation reports whether the error is the one returned by a dry run
+// call that would have succeeded.
+func isDryRunOperation(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "DryRunOperation"
+}
+
+// planShare describes whether the source is shared with the grantee of the
+// launch permission, and whether the copy would share it otherwise, in which
+// case it also reports true.
+func planShare(ctx context.Context, source *types.Image, permission types.LaunchPermission, ensure bool, client *ec2.Client) (string, bool) {
+	grantee := helpers.LaunchPermissionGrantee(permission)
+	shared, err := helpers.IsImageSharedWith(ctx, source, permission, client)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("%s: unknown (%s)", grantee, err), false
+	case shared:
+		return fmt.Sprintf("%s: shared", grantee), false
+	case ensure:
+		return fmt.Sprintf("%s: would share", grantee), true
+	default:
+		return fmt.Sprintf("%s: not shared", grantee), false
+	}
+}
+
+// formatPlan renders the plan as a table.
+func formatPlan(entries []*planEntry) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tREGION\tSOURCE\tLAUNCH PERMISSION\tACTION\tCHECK")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.accountID, e.region, e.source, e.share, e.action, e.check)
+	}
+	w.Flush()
+	return b.String()
+}
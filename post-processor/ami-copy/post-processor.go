@@ -1,31 +1,27 @@
 //go:generate packer-sdc struct-markdown
-//go:generate packer-sdc mapstructure-to-hcl2 -type Config,Target
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config,Target,RetentionConfig,SSMParameterConfig,NotificationConfig
 
 package ami_copy
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"maps"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/mitchellh/mapstructure"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 
-	"github.com/hashicorp/packer-plugin-amazon/builder/chroot"
-	"github.com/hashicorp/packer-plugin-amazon/builder/ebs"
-	"github.com/hashicorp/packer-plugin-amazon/builder/ebssurrogate"
-	"github.com/hashicorp/packer-plugin-amazon/builder/ebsvolume"
-	"github.com/hashicorp/packer-plugin-amazon/builder/instance"
-
 	"github.com/hashicorp/packer-plugin-sdk/common"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 	pkrconfig "github.com/hashicorp/packer-plugin-sdk/template/config"
@@ -50,19 +46,153 @@ type Config struct {
 	// Variables specific to this post-processor
 	RoleName        string `mapstructure:"role_name"`
 	CopyConcurrency int    `mapstructure:"copy_concurrency"`
+	// Wait for the copies to be available. Copies are waited for anyway when
+	// they need an available image: for deprecation or deregistration
+	// protection, including when inherited from the source AMI, fast snapshot
+	// restores, KMS grants, shares and `aws:ec2:image` SSM parameters.
 	EnsureAvailable bool   `mapstructure:"ensure_available"`
 	KeepArtifact    string `mapstructure:"keep_artifact"`
 	ManifestOutput  string `mapstructure:"manifest_output"`
 	TagsOnly        bool   `mapstructure:"tags_only"`
+	// The external ID passed when assuming `role_name` in the accounts of
+	// `ami_users`.
+	RoleExternalID string `mapstructure:"role_external_id"`
+	// The session name used when assuming `role_name` and the roles of
+	// `role_chain`. Defaults to a name generated by the AWS SDK.
+	RoleSessionName string `mapstructure:"role_session_name"`
+	// How long the credentials of `role_name` are valid for, between `15m`
+	// and the maximum session duration of the role. AWS limits sessions of
+	// chained roles to `1h`. Defaults to `15m`.
+	RoleDuration time.Duration `mapstructure:"role_duration"`
+	// ARNs of roles assumed in turn, starting from the source credentials,
+	// before assuming `role_name`, for example a role in a hub account that
+	// is trusted by the accounts of `ami_users`.
+	RoleChain []string `mapstructure:"role_chain"`
+	// How long to wait for a copy to become available with
+	// `ensure_available`. Defaults to `30m`.
+	CopyTimeout time.Duration `mapstructure:"copy_timeout"`
+	// How often to check whether a copy is available. Defaults to `30s`.
+	CopyPollInterval time.Duration `mapstructure:"copy_poll_interval"`
+	// The format of `manifest_output`, either `json` or `yaml`. Defaults to `json`.
+	ManifestFormat string `mapstructure:"manifest_format"`
+	// The path of a manifest in the format of Packer's `manifest`
+	// post-processor. A build listing the copied AMIs is appended to it.
+	PackerManifest string `mapstructure:"packer_manifest"`
+	// Regions to copy the AMIs to in every target account. Defaults to the
+	// region of the source AMI. The KMS key used in each region is taken from
+	// `region_kms_key_ids`, falling back to `kms_key_id`.
+	DestinationRegions []string `mapstructure:"destination_regions"`
+	// IDs of AMIs to copy instead of those of the artifact, either as `ami-id`
+	// in the region of the access config or as `region:ami-id`.
+	SourceAMIIDs []string `mapstructure:"source_ami_ids"`
+	// Filters selecting AMIs to copy instead of those of the artifact, in the
+	// region of the access config. Every matching AMI is copied unless
+	// `most_recent` is set.
+	SourceAMIFilter awscommon.AmiFilterOptions `mapstructure:"source_ami_filter"`
+	// Refuse to copy into an account and region where block public access
+	// for AMIs is not enabled, so that copies can never be made public.
+	RequireBlockPublicAccess bool `mapstructure:"require_block_public_access"`
+	// Enable fast snapshot restore on the snapshots of every copy, in all
+	// availability zones of its region, so that instances launched from it
+	// get fully provisioned volumes. Waits for the copy to be available and
+	// then for fast snapshot restore to be enabled, within `copy_timeout`.
+	// Fast snapshot restore is billed per snapshot and zone, and is disabled
+	// again when the artifact is destroyed.
+	FastLaunch bool `mapstructure:"fast_launch"`
+	// Enable fast snapshot restore only in these availability zones, for
+	// example `us-east-1a`, instead of all zones of the region. Implies
+	// `fast_launch` for the regions of these zones.
+	FastSnapshotRestoreAZs []string `mapstructure:"fast_snapshot_restore_azs"`
+	// Grant each target account the use of the customer managed KMS keys of
+	// the source snapshots while they are copied. The grants are created with
+	// the source credentials and revoked once the copy is available, which
+	// is waited for. Without it, the key policies must already allow the
+	// target accounts to use the keys, which is checked before copying.
+	CreateKMSGrants bool `mapstructure:"create_kms_grants"`
+	// Only print the plan: the account each target resolves to, whether the
+	// source AMIs are shared with it and whether each copy would be made or
+	// reused, validated with dry run calls to `CopyImage` where the source is
+	// already shared. Nothing is shared, copied or tagged, and the artifact is
+	// passed through.
+	DryRun bool `mapstructure:"dry_run"`
+	// Remove the launch and snapshot permissions ami-copy added to the source
+	// AMIs once the copies depending on them are done, leaving permissions
+	// that existed before in place. Copies are waited for to be available.
+	RevokeShareAfterCopy bool `mapstructure:"revoke_share_after_copy"`
+	// A file recording the progress of every copy, so that an interrupted
+	// run can be resumed: copies finished by an earlier run are skipped and
+	// copies still in progress are waited for instead of copying again.
+	StateFile string `mapstructure:"state_file"`
+	// Name and describe the copies with the top-level `ami_name` and
+	// `ami_description`. They are ignored otherwise, as in earlier versions,
+	// so that existing configurations keep the names of their copies. The
+	// `ami_name` and `ami_description` of a target always apply.
+	RenameCopies bool `mapstructure:"rename_copies"`
+
+	// Publishes the ID of each copy to an SSM parameter in the target
+	// account. See the SSM parameter configuration below.
+	SSMParameter SSMParameterConfig `mapstructure:"ssm_parameter"`
+
+	// Sends a message for every finished copy and a summary to SNS topics or
+	// SQS queues. See the notification configuration below.
+	Notifications []NotificationConfig `mapstructure:"notification"`
+
+	// Prunes older copies in the target accounts after a successful run. See
+	// the retention configuration below.
+	Retention RetentionConfig `mapstructure:"retention"`
 
 	Targets []Target `mapstructure:"targets"`
 
 	ctx interpolate.Context
+	// Whether `deregistration_protection` is set, copies inherit the
+	// protection of their source otherwise
+	deregistrationProtectionSet bool
 }
 
 type Target struct {
 	awscommon.AccessConfig `mapstructure:",squash"`
 	Name                   string `mapstructure:"name"`
+	// Regions to copy the AMIs to in this target account, overriding the
+	// top-level `destination_regions`.
+	DestinationRegions []string `mapstructure:"destination_regions"`
+	// A map of regions to the KMS key used to encrypt the copies in that
+	// region, overriding the top-level `region_kms_key_ids`.
+	RegionKMSKeyIDs map[string]string `mapstructure:"region_kms_key_ids"`
+	// The date and time to deprecate the copies in this target account, in
+	// RFC 3339 format, overriding the top-level `deprecate_at`.
+	DeprecateAt string `mapstructure:"deprecate_at"`
+	// Deregistration protection of the copies in this target account,
+	// overriding the top-level `deregistration_protection`.
+	DeregistrationProtection *awscommon.DeregistrationProtectionOptions `mapstructure:"deregistration_protection"`
+	// The name of the copies in this target account, overriding the
+	// top-level `ami_name` of `rename_copies`. Defaults to the name of the
+	// source AMI.
+	AMIName string `mapstructure:"ami_name"`
+	// The description of the copies in this target account, overriding the
+	// top-level `ami_description` of `rename_copies`. Defaults to the
+	// description of the source AMI.
+	AMIDescription string `mapstructure:"ami_description"`
+	// Tags added to the copies in this target account, on top of the source
+	// and top-level `tags`.
+	Tags map[string]string `mapstructure:"tags"`
+	// Tags added to the snapshots of the copies in this target account, on
+	// top of the top-level `snapshot_tags`.
+	SnapshotTags map[string]string `mapstructure:"snapshot_tags"`
+}
+
+// interpolate renders the target with the configuration context, leaving
+// the templates rendered for each copy as they are.
+func (t *Target) interpolate(ctx *interpolate.Context) error {
+	name, description, tags, snapshotTags := t.AMIName, t.AMIDescription, t.Tags, t.SnapshotTags
+	t.AMIName, t.AMIDescription, t.Tags, t.SnapshotTags = "", "", nil, nil
+	defer func() {
+		t.AMIName, t.AMIDescription, t.Tags, t.SnapshotTags = name, description, tags, snapshotTags
+	}()
+
+	if _, err := interpolate.RenderInterface(t, ctx); err != nil {
+		return fmt.Errorf("render target %s: %w", t.Name, err)
+	}
+	return nil
 }
 
 // PostProcessor implements Packer's PostProcessor interface.
@@ -80,21 +210,83 @@ func (p *PostProcessor) ConfigSpec() hcldec.ObjectSpec {
 func (p *PostProcessor) Configure(raws ...any) error {
 	p.config.ctx.Funcs = awscommon.TemplateFuncs
 
+	var md mapstructure.Metadata
 	if err := pkrconfig.Decode(&p.config, &pkrconfig.DecodeOpts{
+		Metadata:           &md,
 		PluginType:         BuilderId,
 		Interpolate:        true,
 		InterpolateContext: &p.config.ctx,
 		InterpolateFilter: &interpolate.RenderFilter{
-			Exclude: []string{},
+			Exclude: []string{
+				"ami_name",
+				"ami_description",
+				"snapshot_tags",
+				"ssm_parameter",
+				"tags",
+				"targets",
+			},
 		},
 	}, raws...); err != nil {
 		return err
 	}
 
+	p.config.deregistrationProtectionSet = slices.Contains(md.Keys, "deregistration_protection")
+
+	// Targets are interpolated like the rest of the configuration, except for
+	// the templates rendered for each copy
+	for i := range p.config.Targets {
+		if err := p.config.Targets[i].interpolate(&p.config.ctx); err != nil {
+			return err
+		}
+	}
+
 	if len(p.config.AMIUsers) == 0 && len(p.config.Targets) == 0 {
 		return errors.New("ami_users or targets must be set")
 	}
 
+	errs := p.config.Retention.Prepare()
+	errs = append(errs, p.config.SSMParameter.Prepare()...)
+	for i := range p.config.Notifications {
+		errs = append(errs, p.config.Notifications[i].Prepare()...)
+	}
+	if p.config.RoleName == "" && (p.config.RoleExternalID != "" || p.config.RoleSessionName != "" ||
+		p.config.RoleDuration != 0 || len(p.config.RoleChain) > 0) {
+		errs = append(errs, fmt.Errorf("role_external_id, role_session_name, role_duration and role_chain require role_name"))
+	}
+	if p.config.RoleDuration != 0 && p.config.RoleDuration < 15*time.Minute {
+		errs = append(errs, fmt.Errorf("role_duration must be at least 15m"))
+	}
+	if p.config.DeprecationTime != "" {
+		if _, err := time.Parse(time.RFC3339, p.config.DeprecationTime); err != nil {
+			errs = append(errs, fmt.Errorf("deprecate_at must be in RFC 3339 format: %w", err))
+		}
+	}
+	for _, tgt := range p.config.Targets {
+		if tgt.DeprecateAt != "" {
+			if _, err := time.Parse(time.RFC3339, tgt.DeprecateAt); err != nil {
+				errs = append(errs, fmt.Errorf("deprecate_at of target %s must be in RFC 3339 format: %w", tgt.Name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	switch p.config.ManifestFormat {
+	case "":
+		p.config.ManifestFormat = ManifestFormatJSON
+	case ManifestFormatJSON, ManifestFormatYAML:
+	default:
+		return fmt.Errorf("manifest_format must be one of %q or %q", ManifestFormatJSON, ManifestFormatYAML)
+	}
+
+	if p.config.CopyTimeout == 0 {
+		p.config.CopyTimeout = 30 * time.Minute
+	}
+	if p.config.CopyPollInterval == 0 {
+		p.config.CopyPollInterval = 30 * time.Second
+	}
+
 	if len(p.config.KeepArtifact) == 0 {
 		p.config.KeepArtifact = "true"
 	}
@@ -116,44 +308,189 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packer.Ui, artifact
 		return artifact, keepArtifactBool, false, err
 	}
 
-	// Ensure we're being called from a supported builder
-	switch artifact.BuilderId() {
-	case ebs.BuilderId,
-		ebssurrogate.BuilderId,
-		ebsvolume.BuilderId,
-		chroot.BuilderId,
-		instance.BuilderId:
-		break
-	default:
-		return artifact, keepArtifactBool, false,
-			fmt.Errorf("Unexpected artifact type: %s\nCan only export from Amazon builders",
-				artifact.BuilderId())
-	}
-
 	// Get AWS config
 	awsCfg, err := p.config.AccessConfig.GetAWSConfig(ctx)
 	if err != nil {
 		return artifact, keepArtifactBool, false, err
 	}
 
-	// Parse AMIs from artifact
-	amis := amisFromArtifactID(artifact.Id())
+	// Resolve the source AMIs from the config or the artifact
+	amis, err := helpers.SourceAMIs(ctx, artifact, p.config.SourceAMIIDs, &p.config.SourceAMIFilter, *awsCfg)
+	if err != nil {
+		return artifact, keepArtifactBool, false, err
+	}
 
 	// Build list of copy operations
+	copies, err := p.copyOperations(ctx, ui, *awsCfg, amis)
+	if err != nil {
+		return artifact, keepArtifactBool, false, err
+	}
+
+	if p.config.DryRun {
+		return artifact, keepArtifactBool, false, planCopies(ui, copies)
+	}
+
+	if p.config.StateFile != "" {
+		state, err := loadState(p.config.StateFile)
+		if err != nil {
+			releaseCopyShares(ctx, ui, copies)
+			return artifact, keepArtifactBool, false, err
+		}
+		for _, c := range copies {
+			c.state = state
+		}
+	}
+
+	// Execute copies
+	var n *notifier
+	if len(p.config.Notifications) > 0 {
+		n = &notifier{configs: p.config.Notifications, source: *awsCfg}
+	}
+	manifests, copyErrs := p.executeCopies(copies, ui, n)
+	manifest := &Manifest{
+		Version: ManifestVersion,
+		Copies:  manifests,
+	}
+	if len(copyErrs.Errors) == 0 && p.config.Retention.enabled() {
+		manifest.Pruned = p.pruneCopies(ctx, ui, copies)
+	}
+	if n != nil {
+		n.notifySummary(ctx, ui, manifest)
+	}
+	if p.config.ManifestOutput != "" {
+		if err := writeManifests(p.config.ManifestOutput, p.config.ManifestFormat, manifest); err != nil {
+			ui.Say(fmt.Sprintf("Unable to write out manifest to %s: %s", p.config.ManifestOutput, err))
+		}
+	}
+	if copyErrCount := len(copyErrs.Errors); copyErrCount > 0 {
+		return artifact, true, false, fmt.Errorf(
+			"%d/%d AMI copies failed, manual reconciliation may be required", copyErrCount, len(copies))
+	}
+
+	copied := &Artifact{
+		BuilderIdValue: BuilderId,
+		StateData:      map[string]any{"generated_data": artifact.State("generated_data")},
+	}
+	for _, c := range copies {
+		copied.Images = append(copied.Images, &CopiedImage{
+			AccountID:     c.targetAccountID,
+			Region:        c.targetRegion,
+			ImageID:       c.copiedImageID,
+			SourceImageID: c.sourceImageID,
+			owned:         !c.tagsOnly && !c.reused,
+			config:        c.targetConfig,
+			fastRestores:  c.fastRestores,
+		})
+	}
+
+	if p.config.PackerManifest != "" {
+		if err := appendPackerManifest(p.config.PackerManifest, p.config.PackerBuildName, copied); err != nil {
+			ui.Say(fmt.Sprintf("Unable to append to Packer manifest %s: %s", p.config.PackerManifest, err))
+		}
+	}
+
+	return copied, keepArtifactBool, false, nil
+}
+
+// roleConfig returns the config of an account of `ami_users` reached through
+// `role_name`. It is the source config, keeping its endpoints and HTTP
+// settings, with the credentials of the role assumed through `role_chain`.
+func (p *PostProcessor) roleConfig(source aws.Config, accountID string) aws.Config {
+	roles := append(slices.Clone(p.config.RoleChain), fmt.Sprintf("arn:aws:iam::%s:role/%s", accountID, p.config.RoleName))
+
+	cfg := source.Copy()
+	for i, role := range roles {
+		last := i == len(roles)-1
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), role, func(o *stscreds.AssumeRoleOptions) {
+			if p.config.RoleSessionName != "" {
+				o.RoleSessionName = p.config.RoleSessionName
+			}
+			if last {
+				if p.config.RoleExternalID != "" {
+					o.ExternalID = aws.String(p.config.RoleExternalID)
+				}
+				if p.config.RoleDuration != 0 {
+					o.Duration = p.config.RoleDuration
+				}
+			}
+		})
+		cfg = cfg.Copy()
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return cfg
+}
+
+// organizationLaunchPermissions returns the launch permissions granting the
+// organizations and OUs of `ami_org_arns` and `ami_ou_arns` access to the
+// source AMIs.
+func (p *PostProcessor) organizationLaunchPermissions() []types.LaunchPermission {
+	var permissions []types.LaunchPermission
+	for _, arn := range p.config.AMIOrgArns {
+		permissions = append(permissions, types.LaunchPermission{OrganizationArn: aws.String(arn)})
+	}
+	for _, arn := range p.config.AMIOuArns {
+		permissions = append(permissions, types.LaunchPermission{OrganizationalUnitArn: aws.String(arn)})
+	}
+	return permissions
+}
+
+// copyOperations prepares a copy operation of every source AMI for each
+// target account and region, sharing the source AMIs as needed. On error, the
+// shares held by the operations prepared so far are released.
+func (p *PostProcessor) copyOperations(ctx context.Context, ui packer.Ui, awsCfg aws.Config, amis []*helpers.AMI) ([]*copyOperation, error) {
 	var copies []*copyOperation
+	fail := func(err error) ([]*copyOperation, error) {
+		releaseCopyShares(ctx, ui, copies)
+		return nil, err
+	}
+
+	// AMIs listed with their account, like the copies of another ami-copy,
+	// are copied from with the credentials of that account
+	var configs map[string]aws.Config
+	if slices.ContainsFunc(amis, func(ami *helpers.AMI) bool { return ami.AccountID != "" }) {
+		var err error
+		if configs, err = p.accountConfigs(ctx, ui, awsCfg); err != nil {
+			return fail(err)
+		}
+	}
+
 	for _, ami := range amis {
 		// Get source image
-		cfg := awsCfg.Copy()
-		cfg.Region = ami.region
+		cfg, err := p.sourceConfig(awsCfg, configs, ami)
+		if err != nil {
+			return fail(err)
+		}
 		client := ec2.NewFromConfig(cfg)
 
-		source, err := helpers.LocateSingleAMI(ctx, ami.id, client)
+		source, err := helpers.LocateSingleAMI(ctx, ami.ID, client)
 		if err != nil || source == nil {
-			return artifact, keepArtifactBool, false, err
+			return fail(err)
 		}
 
 		ui.Sayf("Source Tags: %v", source.Tags)
 
+		// Share the source AMI with the configured organizations and OUs
+		var orgPlan []string
+		var orgUnshared bool
+		var orgShares []*sourceShare
+		for _, permission := range p.organizationLaunchPermissions() {
+			if p.config.DryRun {
+				plan, unshared := planShare(ctx, source, permission, true, client)
+				orgPlan = append(orgPlan, plan)
+				orgUnshared = orgUnshared || unshared
+				continue
+			}
+			share, err := helpers.EnsureImageSharedWith(ctx, source, permission, client)
+			if err != nil {
+				releaseShares(ctx, ui, orgShares)
+				return fail(fmt.Errorf("unable to share AMI %s with %s: %w",
+					ami.ID, helpers.LaunchPermissionGrantee(permission), err))
+			}
+			if share != nil && p.config.RevokeShareAfterCopy {
+				orgShares = append(orgShares, newSourceShare(share, client))
+			}
+		}
+
 		// Create copy operations for each target
 		for _, tgt := range p.config.Targets {
 			targetCfg, err := tgt.GetAWSConfig(ctx)
@@ -161,8 +498,7 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packer.Ui, artifact
 				ui.Error(err.Error())
 				continue
 			}
-			targetCfg.Region = ami.region
-			targetClient := ec2.NewFromConfig(*targetCfg)
+			targetCfg.Region = ami.Region
 
 			// Attempt to resolve the target account ID via STS on the target credentials.
 			stsClient := sts.NewFromConfig(*targetCfg)
@@ -181,96 +517,234 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packer.Ui, artifact
 			}
 			ui.Sayf("Resolved source ARN: %s", *debugclientId.Arn)
 
-			// Ensure that the source AMI is shared with the resolved target account
-			if err = helpers.EnsureImageSharedWith(ctx, source, targetId.Account, client); err != nil {
-				ui.Error(fmt.Sprintf("unable to update AMI launch permissions for account %s: %v", *targetId.Account, err))
-				continue
+			// Ensure that the source AMI is shared with the resolved target
+			// account, even when it is shared with its organization or OU:
+			// copying needs access to the snapshots, which are only ever
+			// shared with accounts
+			var accountShares []*sourceShare
+			permission := types.LaunchPermission{UserId: targetId.Account}
+			plan, unshared := strings.Join(orgPlan, ", "), orgUnshared
+			if p.config.DryRun {
+				accountPlan, accountUnshared := planShare(ctx, source, permission, true, client)
+				plan = strings.Join(append(slices.Clone(orgPlan), accountPlan), ", ")
+				unshared = unshared || accountUnshared
+			} else {
+				share, err := helpers.EnsureImageSharedWith(ctx, source, permission, client)
+				if share != nil && p.config.RevokeShareAfterCopy {
+					accountShares = append(accountShares, newSourceShare(share, client))
+				}
+				if err != nil {
+					ui.Error(fmt.Sprintf("unable to update AMI launch permissions for account %s: %v", *targetId.Account, err))
+					releaseShares(ctx, ui, accountShares)
+					continue
+				}
 			}
-			copy := &copyOperation{
-				ctx:             ctx,
-				client:          targetClient,
-				sourceImage:     source,
-				sourceRegion:    ami.region,
-				sourceImageID:   ami.id,
-				ensureAvailable: p.config.EnsureAvailable,
-				tagsOnly:        p.config.TagsOnly,
-				tags:            p.config.AMITags,
-				encrypted:       p.config.AMIEncryptBootVolume.True(),
-				kmsKeyID:        p.config.AMIKmsKeyId,
-				targetAccountID: *targetId.Account,
+			for _, region := range p.destinationRegions(tgt.DestinationRegions, ami.Region) {
+				c := p.newCopyOperation(ctx, cfg, *targetCfg, source, ami, *targetId.Account, region, &tgt)
+				c.planShare, c.planUnshared = plan, unshared
+				c.holdShares(orgShares)
+				c.holdShares(accountShares)
+				copies = append(copies, c)
 			}
-			copies = append(copies, copy)
+			releaseShares(ctx, ui, accountShares)
 		}
 
 		// Create copy operations for each user (via role assumption)
 		for _, user := range p.config.AMIUsers {
-			var targetClient *ec2.Client
+			targetCfg := awsCfg.Copy()
 			if p.config.RoleName != "" {
-				role := fmt.Sprintf("arn:aws:iam::%s:role/%s", user, p.config.RoleName)
-				stsClient := sts.NewFromConfig(*awsCfg)
-				creds := stscreds.NewAssumeRoleProvider(stsClient, role)
-
-				targetCfg, err := config.LoadDefaultConfig(ctx,
-					config.WithRegion(ami.region),
-					config.WithCredentialsProvider(aws.NewCredentialsCache(creds)),
-				)
-				if err != nil {
-					ui.Error(err.Error())
-					continue
-				}
-				targetClient = ec2.NewFromConfig(targetCfg)
-			} else {
-				cfg := awsCfg.Copy()
-				cfg.Region = ami.region
-				targetClient = ec2.NewFromConfig(cfg)
+				targetCfg = p.roleConfig(awsCfg, user)
 			}
+			targetCfg.Region = ami.Region
 
-			copy := &copyOperation{
-				ctx:             ctx,
-				client:          targetClient,
-				sourceImage:     source,
-				sourceRegion:    ami.region,
-				sourceImageID:   ami.id,
-				ensureAvailable: p.config.EnsureAvailable,
-				tagsOnly:        p.config.TagsOnly,
-				tags:            p.config.AMITags,
-				encrypted:       p.config.AMIEncryptBootVolume.True(),
-				kmsKeyID:        p.config.AMIKmsKeyId,
-				targetAccountID: user,
+			plan, unshared := strings.Join(orgPlan, ", "), orgUnshared
+			if p.config.DryRun && len(orgPlan) == 0 {
+				plan, unshared = planShare(ctx, source, types.LaunchPermission{UserId: aws.String(user)}, false, client)
+			}
+			for _, region := range p.destinationRegions(nil, ami.Region) {
+				c := p.newCopyOperation(ctx, cfg, targetCfg, source, ami, user, region, nil)
+				c.planShare, c.planUnshared = plan, unshared
+				c.holdShares(orgShares)
+				copies = append(copies, c)
 			}
-			copies = append(copies, copy)
 		}
+
+		// Revoke the shares no copy depends on, e.g. when every target failed
+		releaseShares(ctx, ui, orgShares)
 	}
 
-	// Execute copies
-	copyErrs := p.executeCopies(copies, ui)
-	if copyErrCount := len(copyErrs.Errors); copyErrCount > 0 {
-		return artifact, true, false, fmt.Errorf(
-			"%d/%d AMI copies failed, manual reconciliation may be required", copyErrCount, len(copies))
+	return copies, nil
+}
+
+// accountConfigs resolves the account of the configured credentials and of
+// every target, keyed by account ID. Targets that cannot be resolved are only
+// reported, as they are skipped when copying anyway.
+func (p *PostProcessor) accountConfigs(ctx context.Context, ui packer.Ui, awsCfg aws.Config) (map[string]aws.Config, error) {
+	identity, err := sts.NewFromConfig(awsCfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve the source account ID: %w", err)
 	}
+	configs := map[string]aws.Config{aws.ToString(identity.Account): awsCfg}
 
-	return artifact, keepArtifactBool, false, nil
+	for _, tgt := range p.config.Targets {
+		cfg, err := tgt.GetAWSConfig(ctx)
+		if err != nil {
+			ui.Error(err.Error())
+			continue
+		}
+		if cfg.Region == "" {
+			cfg.Region = awsCfg.Region
+		}
+		identity, err := sts.NewFromConfig(*cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			ui.Error(fmt.Sprintf("unable to resolve the account ID of target %s: %v", tgt.Name, err))
+			continue
+		}
+		if _, ok := configs[aws.ToString(identity.Account)]; !ok {
+			configs[aws.ToString(identity.Account)] = *cfg
+		}
+	}
+	return configs, nil
 }
 
-// ami encapsulates simplistic details about an AMI.
-type ami struct {
-	id     string
-	region string
+// sourceConfig returns the config to copy the AMI from, in its region. AMIs
+// listed with their account need the credentials of the matching target, or
+// of `role_name` assumed in that account.
+func (p *PostProcessor) sourceConfig(awsCfg aws.Config, configs map[string]aws.Config, ami *helpers.AMI) (aws.Config, error) {
+	cfg := awsCfg.Copy()
+	if ami.AccountID != "" {
+		accountCfg, ok := configs[ami.AccountID]
+		switch {
+		case ok:
+			cfg = accountCfg.Copy()
+		case p.config.RoleName != "":
+			cfg = p.roleConfig(awsCfg, ami.AccountID)
+		default:
+			return cfg, fmt.Errorf("no credentials for account %s to copy %s:%s:%s from, add it to targets or set role_name",
+				ami.AccountID, ami.AccountID, ami.Region, ami.ID)
+		}
+	}
+	cfg.Region = ami.Region
+	return cfg, nil
 }
 
-// amisFromArtifactID returns an AMI slice from a Packer artifact id.
-func amisFromArtifactID(artifactID string) (amis []*ami) {
-	for _, amiStr := range strings.Split(artifactID, ",") {
-		pair := strings.SplitN(amiStr, ":", 2)
-		amis = append(amis, &ami{region: pair[0], id: pair[1]})
+// releaseCopyShares releases the shares held by copies that are not executed.
+func releaseCopyShares(ctx context.Context, ui packer.Ui, copies []*copyOperation) {
+	for _, c := range copies {
+		releaseShares(ctx, ui, c.shares)
 	}
-	return amis
 }
 
-func writeManifests(output string, manifests []*AmiManifest) error {
-	rawManifest, err := json.Marshal(manifests)
-	if err != nil {
-		return err
+// destinationRegions returns the regions a target gets copies in: its own
+// `destination_regions`, else the top-level ones, else the source region.
+// Tags are only ever copied in place.
+func (p *PostProcessor) destinationRegions(targetRegions []string, sourceRegion string) []string {
+	switch {
+	case p.config.TagsOnly:
+		return []string{sourceRegion}
+	case len(targetRegions) > 0:
+		return targetRegions
+	case len(p.config.DestinationRegions) > 0:
+		return p.config.DestinationRegions
+	default:
+		return []string{sourceRegion}
+	}
+}
+
+// newCopyOperation prepares the copy of the source AMI into the given account
+// and region. The target is nil for copies driven by `ami_users`.
+func (p *PostProcessor) newCopyOperation(ctx context.Context, sourceCfg, targetCfg aws.Config, source *types.Image, ami *helpers.AMI, accountID, region string, tgt *Target) *copyOperation {
+	regionCfg := targetCfg.Copy()
+	regionCfg.Region = region
+
+	kmsKeyID := p.config.AMIKmsKeyId
+	if id, ok := p.config.AMIRegionKMSKeyIDs[region]; ok {
+		kmsKeyID = id
+	}
+	if tgt != nil {
+		if id, ok := tgt.RegionKMSKeyIDs[region]; ok {
+			kmsKeyID = id
+		}
+	}
+
+	deprecateAt := p.config.DeprecationTime
+	protection := p.config.DeregistrationProtection
+	if tgt != nil && tgt.DeprecateAt != "" {
+		deprecateAt = tgt.DeprecateAt
+	}
+	if tgt != nil && tgt.DeregistrationProtection != nil {
+		protection = *tgt.DeregistrationProtection
+	} else if !p.config.deregistrationProtectionSet {
+		// Inherit from the source
+		switch aws.ToString(source.DeregistrationProtection) {
+		case "enabled-with-cooldown":
+			protection = awscommon.DeregistrationProtectionOptions{Enabled: true, WithCooldown: true}
+		case "enabled-without-cooldown":
+			protection = awscommon.DeregistrationProtectionOptions{Enabled: true}
+		default:
+			protection = awscommon.DeregistrationProtectionOptions{}
+		}
+	}
+	if deprecateAt == "" {
+		deprecateAt = aws.ToString(source.DeprecationTime)
+	}
+
+	var nameTemplate, descTemplate string
+	if p.config.RenameCopies {
+		nameTemplate = p.config.AMIName
+		descTemplate = p.config.AMIDescription
+	}
+	tags := maps.Clone(p.config.AMITags)
+	snapshotTags := maps.Clone(p.config.SnapshotTags)
+	if tgt != nil {
+		if tgt.AMIName != "" {
+			nameTemplate = tgt.AMIName
+		}
+		if tgt.AMIDescription != "" {
+			descTemplate = tgt.AMIDescription
+		}
+		if len(tgt.Tags) > 0 {
+			if tags == nil {
+				tags = map[string]string{}
+			}
+			maps.Copy(tags, tgt.Tags)
+		}
+		if len(tgt.SnapshotTags) > 0 {
+			if snapshotTags == nil {
+				snapshotTags = map[string]string{}
+			}
+			maps.Copy(snapshotTags, tgt.SnapshotTags)
+		}
+	}
+
+	return &copyOperation{
+		ctx:             ctx,
+		client:          ec2.NewFromConfig(regionCfg),
+		sourceConfig:    sourceCfg,
+		targetConfig:    regionCfg,
+		sourceImage:     source,
+		sourceRegion:    ami.Region,
+		sourceImageID:   ami.ID,
+		targetRegion:    region,
+		ensureAvailable: p.config.EnsureAvailable,
+		copyTimeout:     p.config.CopyTimeout,
+		pollInterval:    p.config.CopyPollInterval,
+		tagsOnly:        p.config.TagsOnly,
+		tags:            tags,
+		snapshotTags:    snapshotTags,
+		nameTemplate:    nameTemplate,
+		descTemplate:    descTemplate,
+		tmplCtx:         p.config.ctx,
+		encrypted:       p.config.AMIEncryptBootVolume.True(),
+		kmsKeyID:        kmsKeyID,
+		targetAccountID: accountID,
+
+		deprecateAt:              deprecateAt,
+		deregistrationProtection: protection,
+		requireBlockPublicAccess: p.config.RequireBlockPublicAccess,
+		ssmParameter:             &p.config.SSMParameter,
+		fastLaunch:               p.config.FastLaunch,
+		fastRestoreAZs:           p.config.FastSnapshotRestoreAZs,
+		createKMSGrants:          p.config.CreateKMSGrants,
 	}
-	return os.WriteFile(output, rawManifest, 0644)
 }
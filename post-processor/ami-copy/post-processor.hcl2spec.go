@@ -70,6 +70,28 @@ type FlatConfig struct {
 	KeepArtifact                   *string                                     `mapstructure:"keep_artifact" cty:"keep_artifact" hcl:"keep_artifact"`
 	ManifestOutput                 *string                                     `mapstructure:"manifest_output" cty:"manifest_output" hcl:"manifest_output"`
 	TagsOnly                       *bool                                       `mapstructure:"tags_only" cty:"tags_only" hcl:"tags_only"`
+	RoleExternalID                 *string                                     `mapstructure:"role_external_id" cty:"role_external_id" hcl:"role_external_id"`
+	RoleSessionName                *string                                     `mapstructure:"role_session_name" cty:"role_session_name" hcl:"role_session_name"`
+	RoleDuration                   *string                                     `mapstructure:"role_duration" cty:"role_duration" hcl:"role_duration"`
+	RoleChain                      []string                                    `mapstructure:"role_chain" cty:"role_chain" hcl:"role_chain"`
+	CopyTimeout                    *string                                     `mapstructure:"copy_timeout" cty:"copy_timeout" hcl:"copy_timeout"`
+	CopyPollInterval               *string                                     `mapstructure:"copy_poll_interval" cty:"copy_poll_interval" hcl:"copy_poll_interval"`
+	ManifestFormat                 *string                                     `mapstructure:"manifest_format" cty:"manifest_format" hcl:"manifest_format"`
+	PackerManifest                 *string                                     `mapstructure:"packer_manifest" cty:"packer_manifest" hcl:"packer_manifest"`
+	DestinationRegions             []string                                    `mapstructure:"destination_regions" cty:"destination_regions" hcl:"destination_regions"`
+	SourceAMIIDs                   []string                                    `mapstructure:"source_ami_ids" cty:"source_ami_ids" hcl:"source_ami_ids"`
+	SourceAMIFilter                *common.FlatAmiFilterOptions                `mapstructure:"source_ami_filter" cty:"source_ami_filter" hcl:"source_ami_filter"`
+	RequireBlockPublicAccess       *bool                                       `mapstructure:"require_block_public_access" cty:"require_block_public_access" hcl:"require_block_public_access"`
+	FastLaunch                     *bool                                       `mapstructure:"fast_launch" cty:"fast_launch" hcl:"fast_launch"`
+	FastSnapshotRestoreAZs         []string                                    `mapstructure:"fast_snapshot_restore_azs" cty:"fast_snapshot_restore_azs" hcl:"fast_snapshot_restore_azs"`
+	CreateKMSGrants                *bool                                       `mapstructure:"create_kms_grants" cty:"create_kms_grants" hcl:"create_kms_grants"`
+	DryRun                         *bool                                       `mapstructure:"dry_run" cty:"dry_run" hcl:"dry_run"`
+	RevokeShareAfterCopy           *bool                                       `mapstructure:"revoke_share_after_copy" cty:"revoke_share_after_copy" hcl:"revoke_share_after_copy"`
+	StateFile                      *string                                     `mapstructure:"state_file" cty:"state_file" hcl:"state_file"`
+	RenameCopies                   *bool                                       `mapstructure:"rename_copies" cty:"rename_copies" hcl:"rename_copies"`
+	SSMParameter                   *FlatSSMParameterConfig                     `mapstructure:"ssm_parameter" cty:"ssm_parameter" hcl:"ssm_parameter"`
+	Notifications                  []FlatNotificationConfig                    `mapstructure:"notification" cty:"notification" hcl:"notification"`
+	Retention                      *FlatRetentionConfig                        `mapstructure:"retention" cty:"retention" hcl:"retention"`
 	Targets                        []FlatTarget                                `mapstructure:"targets" cty:"targets" hcl:"targets"`
 }
 
@@ -143,31 +165,150 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"keep_artifact":                  &hcldec.AttrSpec{Name: "keep_artifact", Type: cty.String, Required: false},
 		"manifest_output":                &hcldec.AttrSpec{Name: "manifest_output", Type: cty.String, Required: false},
 		"tags_only":                      &hcldec.AttrSpec{Name: "tags_only", Type: cty.Bool, Required: false},
+		"role_external_id":               &hcldec.AttrSpec{Name: "role_external_id", Type: cty.String, Required: false},
+		"role_session_name":              &hcldec.AttrSpec{Name: "role_session_name", Type: cty.String, Required: false},
+		"role_duration":                  &hcldec.AttrSpec{Name: "role_duration", Type: cty.String, Required: false},
+		"role_chain":                     &hcldec.AttrSpec{Name: "role_chain", Type: cty.List(cty.String), Required: false},
+		"copy_timeout":                   &hcldec.AttrSpec{Name: "copy_timeout", Type: cty.String, Required: false},
+		"copy_poll_interval":             &hcldec.AttrSpec{Name: "copy_poll_interval", Type: cty.String, Required: false},
+		"manifest_format":                &hcldec.AttrSpec{Name: "manifest_format", Type: cty.String, Required: false},
+		"packer_manifest":                &hcldec.AttrSpec{Name: "packer_manifest", Type: cty.String, Required: false},
+		"destination_regions":            &hcldec.AttrSpec{Name: "destination_regions", Type: cty.List(cty.String), Required: false},
+		"source_ami_ids":                 &hcldec.AttrSpec{Name: "source_ami_ids", Type: cty.List(cty.String), Required: false},
+		"source_ami_filter":              &hcldec.BlockSpec{TypeName: "source_ami_filter", Nested: hcldec.ObjectSpec((*common.FlatAmiFilterOptions)(nil).HCL2Spec())},
+		"require_block_public_access":    &hcldec.AttrSpec{Name: "require_block_public_access", Type: cty.Bool, Required: false},
+		"fast_launch":                    &hcldec.AttrSpec{Name: "fast_launch", Type: cty.Bool, Required: false},
+		"fast_snapshot_restore_azs":      &hcldec.AttrSpec{Name: "fast_snapshot_restore_azs", Type: cty.List(cty.String), Required: false},
+		"create_kms_grants":              &hcldec.AttrSpec{Name: "create_kms_grants", Type: cty.Bool, Required: false},
+		"dry_run":                        &hcldec.AttrSpec{Name: "dry_run", Type: cty.Bool, Required: false},
+		"revoke_share_after_copy":        &hcldec.AttrSpec{Name: "revoke_share_after_copy", Type: cty.Bool, Required: false},
+		"state_file":                     &hcldec.AttrSpec{Name: "state_file", Type: cty.String, Required: false},
+		"rename_copies":                  &hcldec.AttrSpec{Name: "rename_copies", Type: cty.Bool, Required: false},
+		"ssm_parameter":                  &hcldec.BlockSpec{TypeName: "ssm_parameter", Nested: hcldec.ObjectSpec((*FlatSSMParameterConfig)(nil).HCL2Spec())},
+		"notification":                   &hcldec.BlockListSpec{TypeName: "notification", Nested: hcldec.ObjectSpec((*FlatNotificationConfig)(nil).HCL2Spec())},
+		"retention":                      &hcldec.BlockSpec{TypeName: "retention", Nested: hcldec.ObjectSpec((*FlatRetentionConfig)(nil).HCL2Spec())},
 		"targets":                        &hcldec.BlockListSpec{TypeName: "targets", Nested: hcldec.ObjectSpec((*FlatTarget)(nil).HCL2Spec())},
 	}
 	return s
 }
 
+// FlatNotificationConfig is an auto-generated flat version of NotificationConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatNotificationConfig struct {
+	SNSTopicArn          *string `mapstructure:"sns_topic_arn" cty:"sns_topic_arn" hcl:"sns_topic_arn"`
+	SQSQueueURL          *string `mapstructure:"sqs_queue_url" cty:"sqs_queue_url" hcl:"sqs_queue_url"`
+	UseTargetCredentials *bool   `mapstructure:"use_target_credentials" cty:"use_target_credentials" hcl:"use_target_credentials"`
+}
+
+// FlatMapstructure returns a new FlatNotificationConfig.
+// FlatNotificationConfig is an auto-generated flat version of NotificationConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*NotificationConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatNotificationConfig)
+}
+
+// HCL2Spec returns the hcl spec of a NotificationConfig.
+// This spec is used by HCL to read the fields of NotificationConfig.
+// The decoded values from this spec will then be applied to a FlatNotificationConfig.
+func (*FlatNotificationConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"sns_topic_arn":          &hcldec.AttrSpec{Name: "sns_topic_arn", Type: cty.String, Required: false},
+		"sqs_queue_url":          &hcldec.AttrSpec{Name: "sqs_queue_url", Type: cty.String, Required: false},
+		"use_target_credentials": &hcldec.AttrSpec{Name: "use_target_credentials", Type: cty.Bool, Required: false},
+	}
+	return s
+}
+
+// FlatRetentionConfig is an auto-generated flat version of RetentionConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatRetentionConfig struct {
+	KeepLast *int    `mapstructure:"keep_last" cty:"keep_last" hcl:"keep_last"`
+	KeepDays *int    `mapstructure:"keep_days" cty:"keep_days" hcl:"keep_days"`
+	GroupTag *string `mapstructure:"group_tag" cty:"group_tag" hcl:"group_tag"`
+	DryRun   *bool   `mapstructure:"dry_run" cty:"dry_run" hcl:"dry_run"`
+	Force    *bool   `mapstructure:"force" cty:"force" hcl:"force"`
+}
+
+// FlatMapstructure returns a new FlatRetentionConfig.
+// FlatRetentionConfig is an auto-generated flat version of RetentionConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*RetentionConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatRetentionConfig)
+}
+
+// HCL2Spec returns the hcl spec of a RetentionConfig.
+// This spec is used by HCL to read the fields of RetentionConfig.
+// The decoded values from this spec will then be applied to a FlatRetentionConfig.
+func (*FlatRetentionConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"keep_last": &hcldec.AttrSpec{Name: "keep_last", Type: cty.Number, Required: false},
+		"keep_days": &hcldec.AttrSpec{Name: "keep_days", Type: cty.Number, Required: false},
+		"group_tag": &hcldec.AttrSpec{Name: "group_tag", Type: cty.String, Required: false},
+		"dry_run":   &hcldec.AttrSpec{Name: "dry_run", Type: cty.Bool, Required: false},
+		"force":     &hcldec.AttrSpec{Name: "force", Type: cty.Bool, Required: false},
+	}
+	return s
+}
+
+// FlatSSMParameterConfig is an auto-generated flat version of SSMParameterConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatSSMParameterConfig struct {
+	Name        *string `mapstructure:"name" cty:"name" hcl:"name"`
+	Overwrite   *bool   `mapstructure:"overwrite" cty:"overwrite" hcl:"overwrite"`
+	Tier        *string `mapstructure:"tier" cty:"tier" hcl:"tier"`
+	DataType    *string `mapstructure:"data_type" cty:"data_type" hcl:"data_type"`
+	Description *string `mapstructure:"description" cty:"description" hcl:"description"`
+}
+
+// FlatMapstructure returns a new FlatSSMParameterConfig.
+// FlatSSMParameterConfig is an auto-generated flat version of SSMParameterConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*SSMParameterConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatSSMParameterConfig)
+}
+
+// HCL2Spec returns the hcl spec of a SSMParameterConfig.
+// This spec is used by HCL to read the fields of SSMParameterConfig.
+// The decoded values from this spec will then be applied to a FlatSSMParameterConfig.
+func (*FlatSSMParameterConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"name":        &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"overwrite":   &hcldec.AttrSpec{Name: "overwrite", Type: cty.Bool, Required: false},
+		"tier":        &hcldec.AttrSpec{Name: "tier", Type: cty.String, Required: false},
+		"data_type":   &hcldec.AttrSpec{Name: "data_type", Type: cty.String, Required: false},
+		"description": &hcldec.AttrSpec{Name: "description", Type: cty.String, Required: false},
+	}
+	return s
+}
+
 // FlatTarget is an auto-generated flat version of Target.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatTarget struct {
-	AccessKey             *string                           `mapstructure:"access_key" required:"true" cty:"access_key" hcl:"access_key"`
-	AssumeRole            *common.FlatAssumeRoleConfig      `mapstructure:"assume_role" required:"false" cty:"assume_role" hcl:"assume_role"`
-	CustomEndpointEc2     *string                           `mapstructure:"custom_endpoint_ec2" required:"false" cty:"custom_endpoint_ec2" hcl:"custom_endpoint_ec2"`
-	CredsFilename         *string                           `mapstructure:"shared_credentials_file" required:"false" cty:"shared_credentials_file" hcl:"shared_credentials_file"`
-	DecodeAuthZMessages   *bool                             `mapstructure:"decode_authorization_messages" required:"false" cty:"decode_authorization_messages" hcl:"decode_authorization_messages"`
-	InsecureSkipTLSVerify *bool                             `mapstructure:"insecure_skip_tls_verify" required:"false" cty:"insecure_skip_tls_verify" hcl:"insecure_skip_tls_verify"`
-	MaxRetries            *int                              `mapstructure:"max_retries" required:"false" cty:"max_retries" hcl:"max_retries"`
-	MFACode               *string                           `mapstructure:"mfa_code" required:"false" cty:"mfa_code" hcl:"mfa_code"`
-	ProfileName           *string                           `mapstructure:"profile" required:"false" cty:"profile" hcl:"profile"`
-	RawRegion             *string                           `mapstructure:"region" required:"true" cty:"region" hcl:"region"`
-	SecretKey             *string                           `mapstructure:"secret_key" required:"true" cty:"secret_key" hcl:"secret_key"`
-	SkipMetadataApiCheck  *bool                             `mapstructure:"skip_metadata_api_check" cty:"skip_metadata_api_check" hcl:"skip_metadata_api_check"`
-	SkipCredsValidation   *bool                             `mapstructure:"skip_credential_validation" cty:"skip_credential_validation" hcl:"skip_credential_validation"`
-	Token                 *string                           `mapstructure:"token" required:"false" cty:"token" hcl:"token"`
-	VaultAWSEngine        *common.FlatVaultAWSEngineOptions `mapstructure:"vault_aws_engine" required:"false" cty:"vault_aws_engine" hcl:"vault_aws_engine"`
-	PollingConfig         *common.FlatAWSPollingConfig      `mapstructure:"aws_polling" required:"false" cty:"aws_polling" hcl:"aws_polling"`
-	Name                  *string                           `mapstructure:"name" cty:"name" hcl:"name"`
+	AccessKey                *string                                     `mapstructure:"access_key" required:"true" cty:"access_key" hcl:"access_key"`
+	AssumeRole               *common.FlatAssumeRoleConfig                `mapstructure:"assume_role" required:"false" cty:"assume_role" hcl:"assume_role"`
+	CustomEndpointEc2        *string                                     `mapstructure:"custom_endpoint_ec2" required:"false" cty:"custom_endpoint_ec2" hcl:"custom_endpoint_ec2"`
+	CredsFilename            *string                                     `mapstructure:"shared_credentials_file" required:"false" cty:"shared_credentials_file" hcl:"shared_credentials_file"`
+	DecodeAuthZMessages      *bool                                       `mapstructure:"decode_authorization_messages" required:"false" cty:"decode_authorization_messages" hcl:"decode_authorization_messages"`
+	InsecureSkipTLSVerify    *bool                                       `mapstructure:"insecure_skip_tls_verify" required:"false" cty:"insecure_skip_tls_verify" hcl:"insecure_skip_tls_verify"`
+	MaxRetries               *int                                        `mapstructure:"max_retries" required:"false" cty:"max_retries" hcl:"max_retries"`
+	MFACode                  *string                                     `mapstructure:"mfa_code" required:"false" cty:"mfa_code" hcl:"mfa_code"`
+	ProfileName              *string                                     `mapstructure:"profile" required:"false" cty:"profile" hcl:"profile"`
+	RawRegion                *string                                     `mapstructure:"region" required:"true" cty:"region" hcl:"region"`
+	SecretKey                *string                                     `mapstructure:"secret_key" required:"true" cty:"secret_key" hcl:"secret_key"`
+	SkipMetadataApiCheck     *bool                                       `mapstructure:"skip_metadata_api_check" cty:"skip_metadata_api_check" hcl:"skip_metadata_api_check"`
+	SkipCredsValidation      *bool                                       `mapstructure:"skip_credential_validation" cty:"skip_credential_validation" hcl:"skip_credential_validation"`
+	Token                    *string                                     `mapstructure:"token" required:"false" cty:"token" hcl:"token"`
+	VaultAWSEngine           *common.FlatVaultAWSEngineOptions           `mapstructure:"vault_aws_engine" required:"false" cty:"vault_aws_engine" hcl:"vault_aws_engine"`
+	PollingConfig            *common.FlatAWSPollingConfig                `mapstructure:"aws_polling" required:"false" cty:"aws_polling" hcl:"aws_polling"`
+	Name                     *string                                     `mapstructure:"name" cty:"name" hcl:"name"`
+	DestinationRegions       []string                                    `mapstructure:"destination_regions" cty:"destination_regions" hcl:"destination_regions"`
+	RegionKMSKeyIDs          map[string]string                           `mapstructure:"region_kms_key_ids" cty:"region_kms_key_ids" hcl:"region_kms_key_ids"`
+	DeprecateAt              *string                                     `mapstructure:"deprecate_at" cty:"deprecate_at" hcl:"deprecate_at"`
+	DeregistrationProtection *common.FlatDeregistrationProtectionOptions `mapstructure:"deregistration_protection" cty:"deregistration_protection" hcl:"deregistration_protection"`
+	AMIName                  *string                                     `mapstructure:"ami_name" cty:"ami_name" hcl:"ami_name"`
+	AMIDescription           *string                                     `mapstructure:"ami_description" cty:"ami_description" hcl:"ami_description"`
+	Tags                     map[string]string                           `mapstructure:"tags" cty:"tags" hcl:"tags"`
+	SnapshotTags             map[string]string                           `mapstructure:"snapshot_tags" cty:"snapshot_tags" hcl:"snapshot_tags"`
 }
 
 // FlatMapstructure returns a new FlatTarget.
@@ -199,6 +340,14 @@ func (*FlatTarget) HCL2Spec() map[string]hcldec.Spec {
 		"vault_aws_engine":              &hcldec.BlockSpec{TypeName: "vault_aws_engine", Nested: hcldec.ObjectSpec((*common.FlatVaultAWSEngineOptions)(nil).HCL2Spec())},
 		"aws_polling":                   &hcldec.BlockSpec{TypeName: "aws_polling", Nested: hcldec.ObjectSpec((*common.FlatAWSPollingConfig)(nil).HCL2Spec())},
 		"name":                          &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"destination_regions":           &hcldec.AttrSpec{Name: "destination_regions", Type: cty.List(cty.String), Required: false},
+		"region_kms_key_ids":            &hcldec.AttrSpec{Name: "region_kms_key_ids", Type: cty.Map(cty.String), Required: false},
+		"deprecate_at":                  &hcldec.AttrSpec{Name: "deprecate_at", Type: cty.String, Required: false},
+		"deregistration_protection":     &hcldec.BlockSpec{TypeName: "deregistration_protection", Nested: hcldec.ObjectSpec((*common.FlatDeregistrationProtectionOptions)(nil).HCL2Spec())},
+		"ami_name":                      &hcldec.AttrSpec{Name: "ami_name", Type: cty.String, Required: false},
+		"ami_description":               &hcldec.AttrSpec{Name: "ami_description", Type: cty.String, Required: false},
+		"tags":                          &hcldec.AttrSpec{Name: "tags", Type: cty.Map(cty.String), Required: false},
+		"snapshot_tags":                 &hcldec.AttrSpec{Name: "snapshot_tags", Type: cty.Map(cty.String), Required: false},
 	}
 	return s
 }
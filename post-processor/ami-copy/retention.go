@@ -0,0 +1,217 @@
+//go:generate packer-sdc struct-markdown
+
+package ami_copy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"github.com/bdwyertech/packer-plugin-aws/helpers"
+)
+
+// RetentionConfig controls the pruning of older copies in the target accounts
+// once every copy succeeded. Copies are grouped by the value of `group_tag`
+// on the copied image, and only images made by ami-copy are considered. An
+// image is kept if it is one of the `keep_last` newest of its group or is
+// younger than `keep_days`.
+type RetentionConfig struct {
+	// The number of newest copies to keep in each target account and region,
+	// including the ones just made.
+	KeepLast int `mapstructure:"keep_last"`
+	// Copies created within this many days are kept.
+	KeepDays int `mapstructure:"keep_days"`
+	// The tag whose value groups the copies of the same image, for example
+	// `Name` or `Application`. Required when `keep_last` or `keep_days` is set.
+	GroupTag string `mapstructure:"group_tag"`
+	// Only report the copies that would be removed.
+	DryRun bool `mapstructure:"dry_run"`
+	// Remove copies with deregistration protection as well, disabling it
+	// first. They are kept otherwise.
+	Force bool `mapstructure:"force"`
+}
+
+func (r *RetentionConfig) enabled() bool {
+	return r.KeepLast > 0 || r.KeepDays > 0
+}
+
+// Prepare validates the retention settings.
+func (r *RetentionConfig) Prepare() (errs []error) {
+	if r.KeepLast < 0 {
+		errs = append(errs, fmt.Errorf("retention keep_last must not be negative"))
+	}
+	if r.KeepDays < 0 {
+		errs = append(errs, fmt.Errorf("retention keep_days must not be negative"))
+	}
+	if r.enabled() && r.GroupTag == "" {
+		errs = append(errs, fmt.Errorf("retention group_tag must be set when keep_last or keep_days is set"))
+	}
+	return errs
+}
+
+// PrunedManifest describes an older copy removed by the retention settings.
+type PrunedManifest struct {
+	AccountID    string   `json:"account_id" yaml:"account_id"`
+	Region       string   `json:"region" yaml:"region"`
+	ImageID      string   `json:"image_id" yaml:"image_id"`
+	Name         string   `json:"name,omitempty" yaml:"name,omitempty"`
+	CreationDate string   `json:"creation_date,omitempty" yaml:"creation_date,omitempty"`
+	SnapshotIDs  []string `json:"snapshot_ids,omitempty" yaml:"snapshot_ids,omitempty"`
+	DryRun       bool     `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+	Error        string   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// retentionGroup is a set of copies sharing an account, region and group.
+type retentionGroup struct {
+	accountID string
+	region    string
+	value     string
+	client    *ec2.Client
+	keep      []string
+}
+
+// pruneCopies removes the copies that fall outside the retention settings in
+// every account and region that received a copy.
+func (p *PostProcessor) pruneCopies(ctx context.Context, ui packer.Ui, copies []*copyOperation) []*PrunedManifest {
+	r := &p.config.Retention
+
+	var groups []*retentionGroup
+	for _, c := range copies {
+		if c.tagsOnly {
+			continue
+		}
+		// Group by the tag as rendered on the copy
+		tags, err := c.imageTags()
+		if err != nil {
+			ui.Error(fmt.Sprintf("Not pruning copies of %s in account %s: %s", c.sourceImageID, c.targetAccountID, err))
+			continue
+		}
+		t := slices.IndexFunc(tags, func(tag types.Tag) bool {
+			return aws.ToString(tag.Key) == r.GroupTag
+		})
+		if t < 0 {
+			ui.Say(fmt.Sprintf("Not pruning copies of %s in account %s: source has no %s tag", c.sourceImageID, c.targetAccountID, r.GroupTag))
+			continue
+		}
+		value := aws.ToString(tags[t].Value)
+
+		i := slices.IndexFunc(groups, func(g *retentionGroup) bool {
+			return g.accountID == c.targetAccountID && g.region == c.targetRegion && g.value == value
+		})
+		if i < 0 {
+			groups = append(groups, &retentionGroup{
+				accountID: c.targetAccountID,
+				region:    c.targetRegion,
+				value:     value,
+				client:    c.client,
+			})
+			i = len(groups) - 1
+		}
+		groups[i].keep = append(groups[i].keep, c.copiedImageID)
+	}
+
+	var pruned []*PrunedManifest
+	for _, g := range groups {
+		images, err := describeGroup(ctx, g.client, r.GroupTag, g.value)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Unable to list copies in account %s (%s) for pruning: %s", g.accountID, g.region, err))
+			continue
+		}
+
+		for _, image := range r.expired(images, g.keep, time.Now()) {
+			protected := helpers.DeregistrationProtected(&image)
+			if protected && !r.Force {
+				ui.Say(fmt.Sprintf("Keeping %s in account %s in %s, it has deregistration protection (set force to remove it anyway)",
+					aws.ToString(image.ImageId), g.accountID, g.region))
+				continue
+			}
+
+			m := &PrunedManifest{
+				AccountID:    g.accountID,
+				Region:       g.region,
+				ImageID:      aws.ToString(image.ImageId),
+				Name:         aws.ToString(image.Name),
+				CreationDate: aws.ToString(image.CreationDate),
+				DryRun:       r.DryRun,
+			}
+			for _, bdm := range image.BlockDeviceMappings {
+				if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+					m.SnapshotIDs = append(m.SnapshotIDs, *bdm.Ebs.SnapshotId)
+				}
+			}
+			pruned = append(pruned, m)
+
+			if r.DryRun {
+				ui.Say(fmt.Sprintf("Would remove %s (%s, created %s) from account %s in %s", m.ImageID, m.Name, m.CreationDate, g.accountID, g.region))
+				continue
+			}
+
+			if protected {
+				ui.Say(fmt.Sprintf("Disabling deregistration protection of %s in account %s in %s", m.ImageID, g.accountID, g.region))
+			}
+			ui.Say(fmt.Sprintf("Removing %s (%s, created %s) from account %s in %s", m.ImageID, m.Name, m.CreationDate, g.accountID, g.region))
+			if err := helpers.DestroyImage(ctx, &image, g.client); err != nil {
+				ui.Error(fmt.Sprintf("Unable to remove %s from account %s: %s", m.ImageID, g.accountID, err))
+				m.Error = err.Error()
+			}
+		}
+	}
+
+	return pruned
+}
+
+// describeGroup returns the images owned by the account that were made by
+// ami-copy and carry the given group tag value.
+func describeGroup(ctx context.Context, client *ec2.Client, groupTag, value string) ([]types.Image, error) {
+	var images []types.Image
+	paginator := ec2.NewDescribeImagesPaginator(client, &ec2.DescribeImagesInput{
+		Owners: []string{"self"},
+		Filters: []types.Filter{
+			{Name: aws.String("tag:" + groupTag), Values: []string{value}},
+			{Name: aws.String("tag-key"), Values: []string{SourceAMITag}},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, page.Images...)
+	}
+	return images, nil
+}
+
+// expired returns the images of a group that fall outside the retention
+// settings. Images listed in keep and copies still in progress are never
+// returned.
+func (r *RetentionConfig) expired(images []types.Image, keep []string, now time.Time) []types.Image {
+	sorted := slices.Clone(images)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return aws.ToString(sorted[i].CreationDate) > aws.ToString(sorted[j].CreationDate)
+	})
+
+	var expired []types.Image
+	for i, image := range sorted {
+		if slices.Contains(keep, aws.ToString(image.ImageId)) || image.State == types.ImageStatePending {
+			continue
+		}
+		if r.KeepLast > 0 && i < r.KeepLast {
+			continue
+		}
+		if r.KeepDays > 0 {
+			created, err := time.Parse(time.RFC3339, aws.ToString(image.CreationDate))
+			if err != nil || now.Sub(created) < time.Duration(r.KeepDays)*24*time.Hour {
+				continue
+			}
+		}
+		expired = append(expired, image)
+	}
+	return expired
+}
@@ -0,0 +1,51 @@
+package ami_copy
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"github.com/bdwyertech/packer-plugin-aws/helpers"
+)
+
+// sourceShare is a share of a source AMI added for some copies. It is revoked
+// with `revoke_share_after_copy` once released by every holder: the copies
+// depending on it and the post-processor while it prepares them.
+type sourceShare struct {
+	share  *helpers.ImageShare
+	client *ec2.Client
+	holds  atomic.Int32
+}
+
+// newSourceShare returns a share held by its creator.
+func newSourceShare(share *helpers.ImageShare, client *ec2.Client) *sourceShare {
+	s := &sourceShare{share: share, client: client}
+	s.holds.Store(1)
+	return s
+}
+
+// holdShares makes the copy depend on the shares.
+func (c *copyOperation) holdShares(shares []*sourceShare) {
+	for _, s := range shares {
+		s.holds.Add(1)
+		c.shares = append(c.shares, s)
+	}
+}
+
+// releaseShares releases a hold on each share, revoking those no longer held.
+// Failures are only reported, as the copies are done.
+func releaseShares(ctx context.Context, ui packer.Ui, shares []*sourceShare) {
+	for _, s := range shares {
+		if s.holds.Add(-1) != 0 {
+			continue
+		}
+		ui.Say(fmt.Sprintf("Revoking the share of %s with %s", s.share.ImageID, helpers.LaunchPermissionGrantee(s.share.Permission)))
+		if err := helpers.RevokeImageShare(ctx, s.share, s.client); err != nil {
+			ui.Error(fmt.Sprintf("Unable to revoke the share of %s with %s, revoke it manually: %s",
+				s.share.ImageID, helpers.LaunchPermissionGrantee(s.share.Permission), err))
+		}
+	}
+}
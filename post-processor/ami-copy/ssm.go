@@ -0,0 +1,103 @@
+//go:generate packer-sdc struct-markdown
+
+package ami_copy
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+
+	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
+)
+
+// SSMParameterConfig publishes the ID of each copy to an SSM parameter in the
+// target account and region, written with the target credentials.
+type SSMParameterConfig struct {
+	// The name of the parameter, for example `/golden/windows2022/latest`.
+	// It is a template rendered for each copy, see the template variables
+	// below.
+	Name string `mapstructure:"name"`
+	// Overwrite the parameter if it already exists. Without it, publishing to
+	// an existing parameter fails.
+	Overwrite bool `mapstructure:"overwrite"`
+	// The parameter tier: `Standard`, `Advanced` or `Intelligent-Tiering`.
+	// Defaults to `Standard`.
+	Tier string `mapstructure:"tier"`
+	// The data type of the parameter, `text` or `aws:ec2:image`. With
+	// `aws:ec2:image`, SSM validates that the value is an available AMI, so
+	// the copy is waited for before publishing. Defaults to `text`.
+	DataType string `mapstructure:"data_type"`
+	// A description of the parameter.
+	Description string `mapstructure:"description"`
+}
+
+func (s *SSMParameterConfig) enabled() bool {
+	return s.Name != ""
+}
+
+// needsAvailableImage reports whether SSM only accepts an available AMI as the
+// value of the parameter.
+func (s *SSMParameterConfig) needsAvailableImage() bool {
+	return s.enabled() && s.DataType == "aws:ec2:image"
+}
+
+// Prepare validates the parameter settings and sets the defaults.
+func (s *SSMParameterConfig) Prepare() (errs []error) {
+	if !s.enabled() {
+		return nil
+	}
+
+	if s.Tier == "" {
+		s.Tier = string(ssmtypes.ParameterTierStandard)
+	}
+	if !slices.Contains(ssmtypes.ParameterTier("").Values(), ssmtypes.ParameterTier(s.Tier)) {
+		errs = append(errs, fmt.Errorf("ssm_parameter tier must be one of %v", ssmtypes.ParameterTier("").Values()))
+	}
+
+	if s.DataType == "" {
+		s.DataType = "text"
+	}
+	if s.DataType != "text" && s.DataType != "aws:ec2:image" {
+		errs = append(errs, fmt.Errorf("ssm_parameter data_type must be text or aws:ec2:image"))
+	}
+
+	if err := interpolate.Validate(s.Name, &interpolate.Context{Funcs: awscommon.TemplateFuncs}); err != nil {
+		errs = append(errs, fmt.Errorf("ssm_parameter name is not a valid template: %w", err))
+	}
+
+	return errs
+}
+
+// publishParameter writes the ID of the copy to the SSM parameter.
+func (c *copyOperation) publishParameter(ui packer.Ui) error {
+	name, err := c.render(c.ssmParameter.Name, "")
+	if err != nil {
+		return fmt.Errorf("unable to render SSM parameter name: %w", err)
+	}
+
+	ui.Say(fmt.Sprintf("Publishing %s to SSM parameter %s in account %s (%s)", c.copiedImageID, name, c.targetAccountID, c.targetRegion))
+
+	input := &ssm.PutParameterInput{
+		Name:      aws.String(name),
+		Value:     aws.String(c.copiedImageID),
+		Type:      ssmtypes.ParameterTypeString,
+		Overwrite: aws.Bool(c.ssmParameter.Overwrite),
+		Tier:      ssmtypes.ParameterTier(c.ssmParameter.Tier),
+		DataType:  aws.String(c.ssmParameter.DataType),
+	}
+	if c.ssmParameter.Description != "" {
+		input.Description = aws.String(c.ssmParameter.Description)
+	}
+
+	if _, err := ssm.NewFromConfig(c.targetConfig).PutParameter(c.ctx, input); err != nil {
+		return fmt.Errorf("unable to publish %s to SSM parameter %s: %w", c.copiedImageID, name, err)
+	}
+	c.publishedParameter = name
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package ami_copy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// copyState is the progress of the copies persisted to `state_file`, one
+// manifest entry per copy, so that an interrupted run can be resumed.
+type copyState struct {
+	path   string
+	mu     sync.Mutex
+	copies []*AmiManifest
+}
+
+// loadState reads the state file, which may not exist yet.
+func loadState(path string) (*copyState, error) {
+	s := &copyState{path: path}
+
+	manifest, err := ReadManifest(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s.copies = manifest.Copies
+	return s, nil
+}
+
+// lookup returns the recorded copy of the source into the target account and
+// region, if any.
+func (s *copyState) lookup(c *copyOperation) *AmiManifest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.copies, func(m *AmiManifest) bool {
+		return m.AccountID == c.targetAccountID && m.Region == c.targetRegion && m.SourceImageID == c.sourceImageID
+	})
+	if i < 0 {
+		return nil
+	}
+	return s.copies[i]
+}
+
+// record replaces the record of the copy and writes the state file. The file
+// is replaced atomically so that it is never left half written.
+func (s *copyState) record(m *AmiManifest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.copies, func(r *AmiManifest) bool {
+		return r.AccountID == m.AccountID && r.Region == m.Region && r.SourceImageID == m.SourceImageID
+	})
+	if i < 0 {
+		s.copies = append(s.copies, m)
+	} else {
+		s.copies[i] = m
+	}
+
+	raw, err := json.MarshalIndent(&Manifest{Version: ManifestVersion, Copies: s.copies}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// recordState records the copy with the given status in the state file.
+func (c *copyOperation) recordState(ui packer.Ui, m *AmiManifest) {
+	if c.state == nil {
+		return
+	}
+	if err := c.state.record(m); err != nil {
+		ui.Error(fmt.Sprintf("Unable to record the progress of %s in account %s to %s: %s", c.sourceImageID, c.targetAccountID, c.state.path, err))
+	}
+}
+
+// resume picks up the copy recorded by an earlier run. A finished copy that
+// is still available is skipped altogether, and reports true. A copy still in
+// progress is waited for instead of copying again. Copies that no longer
+// exist, or that are not copies of the source, are made again.
+func (c *copyOperation) resume(ui packer.Ui) bool {
+	record := c.state.lookup(c)
+	if record == nil || record.ImageID == "" {
+		return false
+	}
+
+	image, err := c.locateRecordedCopy(record.ImageID)
+	if err != nil {
+		ui.Say(fmt.Sprintf("Unable to locate recorded copy %s of %s in account %s, copying again: %s", record.ImageID, c.sourceImageID, c.targetAccountID, err))
+		return false
+	}
+
+	finished := slices.Contains([]string{StatusCopied, StatusReused, StatusResumed, StatusSkipped}, record.Status)
+	switch {
+	case image.State == types.ImageStateAvailable && finished:
+		ui.Say(fmt.Sprintf("Skipping %s in account %s (%s), copied to %s by an earlier run", c.sourceImageID, c.targetAccountID, c.targetRegion, record.ImageID))
+		c.resumeStatus = StatusSkipped
+	case image.State == types.ImageStateAvailable || image.State == types.ImageStatePending:
+		ui.Say(fmt.Sprintf("Resuming copy %s of %s in account %s (%s)", record.ImageID, c.sourceImageID, c.targetAccountID, c.targetRegion))
+		c.resumeStatus = StatusResumed
+	default:
+		return false
+	}
+
+	c.copiedImageID = record.ImageID
+	c.copiedImage = image
+	c.reused = true
+	if c.name == "" {
+		c.name = aws.ToString(image.Name)
+	}
+	return c.resumeStatus == StatusSkipped
+}
+
+// locateRecordedCopy looks up the copy recorded in the state file. The state
+// file may be stale or edited, so the image must be owned by the target
+// account and have been copied from, or tagged with, the source.
+func (c *copyOperation) locateRecordedCopy(imageID string) (*types.Image, error) {
+	output, err := c.client.DescribeImages(c.ctx, &ec2.DescribeImagesInput{
+		Owners:   []string{"self"},
+		ImageIds: []string{imageID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Images) != 1 {
+		return nil, fmt.Errorf("image %s not found", imageID)
+	}
+
+	image := &output.Images[0]
+	if aws.ToString(image.ImageId) == c.sourceImageID || c.copiedFromOtherSource(image) || !c.copiedFromSource(image) {
+		return nil, fmt.Errorf("image %s is not a copy of %s", imageID, c.sourceImageID)
+	}
+	return image, nil
+}
+
+// copiedFromSource reports whether the image is tagged as, or was copied from,
+// the source.
+func (c *copyOperation) copiedFromSource(image *types.Image) bool {
+	if aws.ToString(image.SourceImageId) == c.sourceImageID {
+		return true
+	}
+	return slices.ContainsFunc(image.Tags, func(tag types.Tag) bool {
+		return aws.ToString(tag.Key) == SourceAMITag && aws.ToString(tag.Value) == c.sourceImageID
+	})
+}
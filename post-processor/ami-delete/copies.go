@@ -0,0 +1,184 @@
+package ami_delete
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"github.com/bdwyertech/packer-plugin-aws/helpers"
+	ami_copy "github.com/bdwyertech/packer-plugin-aws/post-processor/ami-copy"
+)
+
+// copies finds the copies ami-copy made of the source AMIs, in the accounts
+// and regions of `targets` and in `copy_manifest`. It also returns the config
+// of every account it could reach, keyed by account ID, which is needed as
+// well for source AMIs listed with their account. Accounts that cannot be
+// searched are reported as errors, the copies found elsewhere are still
+// returned.
+func (p *PostProcessor) copies(ctx context.Context, ui packer.Ui, awsCfg aws.Config, sources []*helpers.AMI) ([]*helpers.AMI, map[string]aws.Config, *packer.MultiError) {
+	withAccount := slices.ContainsFunc(sources, func(source *helpers.AMI) bool {
+		return source.AccountID != ""
+	})
+	if len(p.config.Targets) == 0 && p.config.CopyManifest == "" && !withAccount {
+		return nil, nil, nil
+	}
+
+	var errs *packer.MultiError
+	configs := map[string]aws.Config{}
+	if id, err := accountID(ctx, awsCfg); err != nil {
+		errs = packer.MultiErrorAppend(errs, err)
+	} else {
+		configs[id] = awsCfg
+	}
+
+	var found []*helpers.AMI
+	add := func(ami *helpers.AMI) {
+		same := func(other *helpers.AMI) bool {
+			return other.ID == ami.ID && other.Region == ami.Region
+		}
+		if slices.ContainsFunc(sources, same) || slices.ContainsFunc(found, same) {
+			return
+		}
+		ui.Sayf("Found copy %s in account %s (%s)", ami.ID, ami.AccountID, ami.Region)
+		found = append(found, ami)
+	}
+
+	for _, tgt := range p.config.Targets {
+		cfg, err := tgt.GetAWSConfig(ctx)
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("unable to configure target %s: %w", tgt.Name, err))
+			continue
+		}
+		if cfg.Region == "" {
+			cfg.Region = awsCfg.Region
+		}
+		id, err := accountID(ctx, *cfg)
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("target %s: %w", tgt.Name, err))
+			continue
+		}
+		configs[id] = *cfg
+
+		for _, source := range sources {
+			for _, region := range p.destinationRegions(tgt.DestinationRegions, source.Region) {
+				regionCfg := cfg.Copy()
+				regionCfg.Region = region
+				copies, err := findCopies(ctx, ec2.NewFromConfig(regionCfg), source.ID, id, region)
+				if err != nil {
+					errs = packer.MultiErrorAppend(errs, err)
+					continue
+				}
+				for _, c := range copies {
+					add(c)
+				}
+			}
+		}
+	}
+
+	if p.config.CopyManifest != "" {
+		manifest, err := ami_copy.ReadManifest(p.config.CopyManifest)
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, err)
+		} else {
+			for _, c := range manifestCopies(manifest, sources) {
+				if _, ok := configs[c.AccountID]; !ok {
+					errs = packer.MultiErrorAppend(errs, fmt.Errorf("no credentials for account %s to delete %s:%s of the manifest, add it to targets", c.AccountID, c.Region, c.ID))
+					continue
+				}
+				add(c)
+			}
+		}
+	}
+
+	return found, configs, errs
+}
+
+// destinationRegions returns the regions to search a target for copies in.
+func (p *PostProcessor) destinationRegions(targetRegions []string, sourceRegion string) []string {
+	switch {
+	case len(targetRegions) > 0:
+		return targetRegions
+	case len(p.config.DestinationRegions) > 0:
+		return p.config.DestinationRegions
+	default:
+		return []string{sourceRegion}
+	}
+}
+
+// findCopies returns the AMIs of the account in the region that are copies of
+// the source AMI, either tagged with it by ami-copy or with it as their source
+// image.
+func findCopies(ctx context.Context, client *ec2.Client, sourceID, accountID, region string) ([]*helpers.AMI, error) {
+	var copies []*helpers.AMI
+	for _, filter := range []types.Filter{
+		{Name: aws.String("tag:" + ami_copy.SourceAMITag), Values: []string{sourceID}},
+		{Name: aws.String("source-image-id"), Values: []string{sourceID}},
+	} {
+		paginator := ec2.NewDescribeImagesPaginator(client, &ec2.DescribeImagesInput{
+			Owners:  []string{"self"},
+			Filters: []types.Filter{filter},
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("unable to find copies of %s in account %s (%s): %w", sourceID, accountID, region, err)
+			}
+			for _, image := range page.Images {
+				id := aws.ToString(image.ImageId)
+				if id == sourceID || slices.ContainsFunc(copies, func(c *helpers.AMI) bool { return c.ID == id }) {
+					continue
+				}
+				copies = append(copies, &helpers.AMI{AccountID: accountID, Region: region, ID: id})
+			}
+		}
+	}
+	return copies, nil
+}
+
+// manifestCopies returns the copies of the source AMIs listed in an ami-copy
+// manifest.
+func manifestCopies(manifest *ami_copy.Manifest, sources []*helpers.AMI) []*helpers.AMI {
+	var copies []*helpers.AMI
+	for _, m := range manifest.Copies {
+		if m.ImageID == "" {
+			continue
+		}
+		if !slices.ContainsFunc(sources, func(source *helpers.AMI) bool {
+			return source.ID == m.SourceImageID && (m.SourceRegion == "" || source.Region == m.SourceRegion)
+		}) {
+			continue
+		}
+		copies = append(copies, &helpers.AMI{AccountID: m.AccountID, Region: m.Region, ID: m.ImageID})
+	}
+	return copies
+}
+
+// amiConfig returns the config to delete the AMI with, in its region. AMIs
+// listed with their account need the config of that account.
+func amiConfig(awsCfg aws.Config, configs map[string]aws.Config, ami *helpers.AMI) (aws.Config, error) {
+	cfg := awsCfg.Copy()
+	if ami.AccountID != "" {
+		accountCfg, ok := configs[ami.AccountID]
+		if !ok {
+			return cfg, fmt.Errorf("no credentials for account %s to delete %s, add it to targets", ami.AccountID, amiName(ami))
+		}
+		cfg = accountCfg.Copy()
+	}
+	cfg.Region = ami.Region
+	return cfg, nil
+}
+
+// accountID resolves the account of the config.
+func accountID(ctx context.Context, cfg aws.Config) (string, error) {
+	identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve the account ID: %w", err)
+	}
+	return aws.ToString(identity.Account), nil
+}
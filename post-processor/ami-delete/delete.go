@@ -0,0 +1,189 @@
+package ami_delete
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"github.com/bdwyertech/packer-plugin-aws/helpers"
+)
+
+// Outcomes of a deletion reported in the summary.
+const (
+	statusDeleted     = "deleted"
+	statusWouldDelete = "would delete"
+	statusRefused     = "refused"
+	statusFailed      = "failed"
+)
+
+// deletion is an AMI to delete and what became of it.
+type deletion struct {
+	ami       *helpers.AMI
+	snapshots []string
+	status    string
+	reason    string
+}
+
+// deleteAMI deregisters the AMI and deletes its snapshots, unless it has
+// deregistration protection or is still in use and `force` is not set. Every
+// snapshot is attempted even if some fail. With `dry_run`, only the checks
+// are made.
+func (p *PostProcessor) deleteAMI(ctx context.Context, ui packer.Ui, client *ec2.Client, ami *helpers.AMI) (*deletion, error) {
+	d := &deletion{ami: ami}
+	fail := func(err error) (*deletion, error) {
+		d.status = statusFailed
+		d.reason = err.Error()
+		return d, fmt.Errorf("%s: %w", amiName(ami), err)
+	}
+
+	img, err := helpers.LocateSingleAMI(ctx, ami.ID, client)
+	if err != nil {
+		return fail(err)
+	}
+	for _, bdm := range img.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+			d.snapshots = append(d.snapshots, *bdm.Ebs.SnapshotId)
+		}
+	}
+
+	protected := deregistrationProtected(img)
+	blockers, err := inUse(ctx, ami.ID, client)
+	if err != nil {
+		return fail(err)
+	}
+	if protected {
+		blockers = append([]string{"deregistration protection is enabled"}, blockers...)
+	}
+	if len(blockers) > 0 {
+		if !p.config.Force {
+			d.status = statusRefused
+			d.reason = strings.Join(blockers, "; ")
+			return d, fmt.Errorf("refusing to delete %s, set force to delete it anyway: %s", amiName(ami), d.reason)
+		}
+		ui.Sayf("Forcing deletion of %s: %s", ami.ID, strings.Join(blockers, "; "))
+	}
+
+	if p.config.DryRun {
+		d.status = statusWouldDelete
+		return d, nil
+	}
+
+	if protected {
+		ui.Sayf("Disabling deregistration protection of %s", ami.ID)
+		if _, err := client.DisableImageDeregistrationProtection(ctx, &ec2.DisableImageDeregistrationProtectionInput{
+			ImageId: img.ImageId,
+		}); err != nil {
+			return fail(fmt.Errorf("unable to disable deregistration protection: %w", err))
+		}
+	}
+
+	ui.Sayf("Deregistering %s", ami.ID)
+	if _, err := client.DeregisterImage(ctx, &ec2.DeregisterImageInput{
+		ImageId: img.ImageId,
+	}); err != nil {
+		return fail(err)
+	}
+
+	var failed []string
+	for _, snapshot := range d.snapshots {
+		ui.Sayf("Deleting %s", snapshot)
+		if _, err := client.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String(snapshot),
+		}); err != nil {
+			failed = append(failed, fmt.Sprintf("unable to delete %s: %s", snapshot, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fail(errors.New(strings.Join(failed, "; ")))
+	}
+
+	d.status = statusDeleted
+	return d, nil
+}
+
+// amiName formats the AMI as it is listed in artifact IDs.
+func amiName(ami *helpers.AMI) string {
+	if ami.AccountID != "" {
+		return fmt.Sprintf("%s:%s:%s", ami.AccountID, ami.Region, ami.ID)
+	}
+	return fmt.Sprintf("%s:%s", ami.Region, ami.ID)
+}
+
+// deregistrationProtected reports whether the AMI has deregistration
+// protection, with or without a cooldown period.
+func deregistrationProtected(img *types.Image) bool {
+	protection := aws.ToString(img.DeregistrationProtection)
+	return protection != "" && protection != "disabled"
+}
+
+// inUse describes the running instances and the latest and default versions
+// of launch templates that use the AMI, in its account and region.
+func inUse(ctx context.Context, id string, client *ec2.Client) ([]string, error) {
+	var instances []string
+	instancePages := ec2.NewDescribeInstancesPaginator(client, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("image-id"), Values: []string{id}},
+			{Name: aws.String("instance-state-name"), Values: []string{"pending", "running"}},
+		},
+	})
+	for instancePages.HasMorePages() {
+		page, err := instancePages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list instances using %s: %w", id, err)
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				instances = append(instances, aws.ToString(instance.InstanceId))
+			}
+		}
+	}
+
+	var templates []string
+	templatePages := ec2.NewDescribeLaunchTemplateVersionsPaginator(client, &ec2.DescribeLaunchTemplateVersionsInput{
+		Versions: []string{"$Latest", "$Default"},
+		Filters: []types.Filter{
+			{Name: aws.String("image-id"), Values: []string{id}},
+		},
+	})
+	for templatePages.HasMorePages() {
+		page, err := templatePages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list launch templates using %s: %w", id, err)
+		}
+		for _, version := range page.LaunchTemplateVersions {
+			template := fmt.Sprintf("%s (version %d)", aws.ToString(version.LaunchTemplateId), aws.ToInt64(version.VersionNumber))
+			if !slices.Contains(templates, template) {
+				templates = append(templates, template)
+			}
+		}
+	}
+
+	var blockers []string
+	if len(instances) > 0 {
+		blockers = append(blockers, "used by instances "+strings.Join(instances, ", "))
+	}
+	if len(templates) > 0 {
+		blockers = append(blockers, "used by launch templates "+strings.Join(templates, ", "))
+	}
+	return blockers, nil
+}
+
+// formatSummary renders the outcome of every deletion as a table.
+func formatSummary(deletions []*deletion) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tREGION\tAMI\tSNAPSHOTS\tSTATUS\tREASON")
+	for _, d := range deletions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", d.ami.AccountID, d.ami.Region, d.ami.ID, strings.Join(d.snapshots, ", "), d.status, d.reason)
+	}
+	w.Flush()
+	return b.String()
+}
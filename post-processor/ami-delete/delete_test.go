@@ -0,0 +1,224 @@
+package ami_delete
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"github.com/bdwyertech/packer-plugin-aws/helpers"
+	ami_copy "github.com/bdwyertech/packer-plugin-aws/post-processor/ami-copy"
+)
+
+// newTestEC2Client returns an EC2 client served by respond, which returns the
+// body of the response to the action. Errors are returned as an EC2 error
+// response with the code of an error returned by respond.
+func newTestEC2Client(t *testing.T, respond func(action string, r *http.Request) (string, error)) *ec2.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing request: %v", err)
+		}
+		action := r.Form.Get("Action")
+		w.Header().Set("Content-Type", "text/xml")
+		body, err := respond(action, r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `<Response><Errors><Error><Code>%s</Code><Message>%[1]s</Message></Error></Errors><RequestID>test</RequestID></Response>`, err)
+			return
+		}
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>test</requestId>%[2]s</%[1]sResponse>`, action, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return ec2.NewFromConfig(aws.Config{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+	}, func(o *ec2.Options) {
+		o.BaseEndpoint = aws.String(server.URL)
+		o.RetryMaxAttempts = 1
+	})
+}
+
+func TestDeleteAMI(t *testing.T) {
+	const image = `<imagesSet><item>
+		<imageId>ami-1</imageId>
+		<deregistrationProtection>enabled</deregistrationProtection>
+		<blockDeviceMapping>
+			<item><deviceName>/dev/xvda</deviceName><ebs><snapshotId>snap-1</snapshotId></ebs></item>
+			<item><deviceName>/dev/xvdb</deviceName><ebs><snapshotId>snap-2</snapshotId></ebs></item>
+		</blockDeviceMapping>
+	</item></imagesSet>`
+
+	for _, tt := range []struct {
+		name    string
+		config  Config
+		status  string
+		err     string
+		actions []string
+	}{
+		{
+			name:    "refused",
+			status:  statusRefused,
+			err:     "refusing to delete us-east-1:ami-1, set force to delete it anyway: deregistration protection is enabled; used by instances i-1; used by launch templates lt-1 (version 3)",
+			actions: []string{"DescribeImages", "DescribeInstances", "DescribeLaunchTemplateVersions"},
+		},
+		{
+			name:    "dry run",
+			config:  Config{DryRun: true, Force: true},
+			status:  statusWouldDelete,
+			actions: []string{"DescribeImages", "DescribeInstances", "DescribeLaunchTemplateVersions"},
+		},
+		{
+			name:   "forced",
+			config: Config{Force: true},
+			status: statusFailed,
+			err:    "us-east-1:ami-1: unable to delete snap-1: operation error EC2: DeleteSnapshot",
+			actions: []string{
+				"DescribeImages", "DescribeInstances", "DescribeLaunchTemplateVersions",
+				"DisableImageDeregistrationProtection", "DeregisterImage", "DeleteSnapshot", "DeleteSnapshot",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var actions []string
+			client := newTestEC2Client(t, func(action string, r *http.Request) (string, error) {
+				actions = append(actions, action)
+				switch action {
+				case "DescribeImages":
+					return image, nil
+				case "DescribeInstances":
+					return `<reservationSet><item><instancesSet><item><instanceId>i-1</instanceId></item></instancesSet></item></reservationSet>`, nil
+				case "DescribeLaunchTemplateVersions":
+					return `<launchTemplateVersionSet>
+						<item><launchTemplateId>lt-1</launchTemplateId><versionNumber>3</versionNumber></item>
+						<item><launchTemplateId>lt-1</launchTemplateId><versionNumber>3</versionNumber></item>
+					</launchTemplateVersionSet>`, nil
+				case "DisableImageDeregistrationProtection":
+					return "<return>disabled</return>", nil
+				case "DeregisterImage":
+					return "<return>true</return>", nil
+				case "DeleteSnapshot":
+					if r.Form.Get("SnapshotId") == "snap-1" {
+						return "", fmt.Errorf("InvalidSnapshot.InUse")
+					}
+					return "<return>true</return>", nil
+				default:
+					t.Errorf("unexpected %s request", action)
+					return "", nil
+				}
+			})
+
+			p := &PostProcessor{config: tt.config}
+			d, err := p.deleteAMI(context.Background(), packersdk.TestUi(t), client, &helpers.AMI{Region: "us-east-1", ID: "ami-1"})
+
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+			if d.status != tt.status {
+				t.Fatalf("expected status %q, got %q", tt.status, d.status)
+			}
+			if !slices.Equal(d.snapshots, []string{"snap-1", "snap-2"}) {
+				t.Fatalf("unexpected snapshots %v", d.snapshots)
+			}
+			if !slices.Equal(actions, tt.actions) {
+				t.Fatalf("expected requests %v, got %v", tt.actions, actions)
+			}
+		})
+	}
+}
+
+func TestFindCopies(t *testing.T) {
+	client := newTestEC2Client(t, func(action string, r *http.Request) (string, error) {
+		if action != "DescribeImages" {
+			t.Errorf("unexpected %s request", action)
+			return "", nil
+		}
+		if r.Form.Get("Owner.1") != "self" || r.Form.Get("Filter.1.Value.1") != "ami-src" {
+			t.Errorf("unexpected DescribeImages request: %v", r.Form)
+		}
+		switch r.Form.Get("Filter.1.Name") {
+		case "tag:" + ami_copy.SourceAMITag:
+			return `<imagesSet><item><imageId>ami-tagged</imageId></item></imagesSet>`, nil
+		case "source-image-id":
+			return `<imagesSet><item><imageId>ami-tagged</imageId></item><item><imageId>ami-copied</imageId></item></imagesSet>`, nil
+		}
+		t.Errorf("unexpected filter %s", r.Form.Get("Filter.1.Name"))
+		return "<imagesSet/>", nil
+	})
+
+	copies, err := findCopies(context.Background(), client, "ami-src", "222222222222", "eu-west-1")
+	if err != nil {
+		t.Fatalf("findCopies failed: %v", err)
+	}
+	var names []string
+	for _, c := range copies {
+		names = append(names, amiName(c))
+	}
+	if !slices.Equal(names, []string{"222222222222:eu-west-1:ami-tagged", "222222222222:eu-west-1:ami-copied"}) {
+		t.Fatalf("unexpected copies %v", names)
+	}
+}
+
+func TestManifestCopies(t *testing.T) {
+	manifest := &ami_copy.Manifest{Copies: []*ami_copy.AmiManifest{
+		{AccountID: "222222222222", Region: "eu-west-1", ImageID: "ami-1", SourceImageID: "ami-src", SourceRegion: "us-east-1"},
+		{AccountID: "222222222222", Region: "eu-west-1", SourceImageID: "ami-src", SourceRegion: "us-east-1", Status: ami_copy.StatusFailed},
+		{AccountID: "222222222222", Region: "eu-west-1", ImageID: "ami-2", SourceImageID: "ami-src", SourceRegion: "us-west-2"},
+		{AccountID: "333333333333", Region: "us-east-1", ImageID: "ami-3", SourceImageID: "ami-other", SourceRegion: "us-east-1"},
+	}}
+
+	copies := manifestCopies(manifest, []*helpers.AMI{{Region: "us-east-1", ID: "ami-src"}})
+	if len(copies) != 1 || amiName(copies[0]) != "222222222222:eu-west-1:ami-1" {
+		t.Fatalf("expected only the copy of us-east-1:ami-src, got %v", copies)
+	}
+}
+
+func TestAMIConfig(t *testing.T) {
+	awsCfg := aws.Config{Region: "us-east-1", AppID: "caller"}
+	configs := map[string]aws.Config{
+		"111111111111": awsCfg,
+		"222222222222": {Region: "us-east-1", AppID: "target"},
+	}
+
+	for _, tt := range []struct {
+		ami   helpers.AMI
+		appID string
+		err   string
+	}{
+		{ami: helpers.AMI{Region: "eu-west-1", ID: "ami-1"}, appID: "caller"},
+		{ami: helpers.AMI{AccountID: "111111111111", Region: "eu-west-1", ID: "ami-1"}, appID: "caller"},
+		{ami: helpers.AMI{AccountID: "222222222222", Region: "eu-west-1", ID: "ami-1"}, appID: "target"},
+		{
+			ami: helpers.AMI{AccountID: "333333333333", Region: "eu-west-1", ID: "ami-1"},
+			err: "no credentials for account 333333333333 to delete 333333333333:eu-west-1:ami-1, add it to targets",
+		},
+	} {
+		t.Run(amiName(&tt.ami), func(t *testing.T) {
+			cfg, err := amiConfig(awsCfg, configs, &tt.ami)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.AppID != tt.appID || cfg.Region != "eu-west-1" {
+				t.Fatalf("unexpected config %s in %s", cfg.AppID, cfg.Region)
+			}
+		})
+	}
+}
@@ -5,19 +5,10 @@ package ami_delete
 
 import (
 	"context"
-	"fmt"
-	"strings"
 
 	"github.com/hashicorp/hcl/v2/hcldec"
 
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
-	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
-
-	"github.com/hashicorp/packer-plugin-amazon/builder/chroot"
-	"github.com/hashicorp/packer-plugin-amazon/builder/ebs"
-	"github.com/hashicorp/packer-plugin-amazon/builder/ebssurrogate"
-	"github.com/hashicorp/packer-plugin-amazon/builder/ebsvolume"
-	"github.com/hashicorp/packer-plugin-amazon/builder/instance"
 
 	"github.com/hashicorp/packer-plugin-sdk/common"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
@@ -27,6 +18,7 @@ import (
 	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
 
 	"github.com/bdwyertech/packer-plugin-aws/helpers"
+	ami_copy "github.com/bdwyertech/packer-plugin-aws/post-processor/ami-copy"
 )
 
 // BuilderId is the ID of this post processor.
@@ -40,6 +32,33 @@ type Config struct {
 	awscommon.AccessConfig `mapstructure:",squash"`
 	awscommon.AMIConfig    `mapstructure:",squash"`
 
+	// IDs of AMIs to delete instead of those of the artifact, either as
+	// `ami-id` in the region of the access config or as `region:ami-id`.
+	SourceAMIIDs []string `mapstructure:"source_ami_ids"`
+	// Filters selecting AMIs to delete instead of those of the artifact, in
+	// the region of the access config. Every matching AMI is deleted unless
+	// `most_recent` is set.
+	SourceAMIFilter awscommon.AmiFilterOptions `mapstructure:"source_ami_filter"`
+	// Accounts to also delete the copies ami-copy made of the AMIs in, in the
+	// shape of the `targets` of ami-copy. Only the access config and
+	// `destination_regions` of a target are used. Copies are found by the
+	// `ami-copy:source-ami` tag or by their source image ID.
+	Targets []ami_copy.Target `mapstructure:"targets"`
+	// Regions to search the targets for copies in, unless set on the target.
+	// Defaults to the region of each AMI.
+	DestinationRegions []string `mapstructure:"destination_regions"`
+	// An ami-copy manifest, as written to its `manifest_output`. The copies it
+	// lists of the AMIs are deleted too, with the credentials of the access
+	// config or of the target of their account.
+	CopyManifest string `mapstructure:"copy_manifest"`
+	// Check and report the AMIs that would be deleted without deleting
+	// anything.
+	DryRun bool `mapstructure:"dry_run"`
+	// Delete AMIs even if they have deregistration protection, which is
+	// disabled first, or are used by running instances or by the latest or
+	// default version of launch templates. Such AMIs are refused otherwise.
+	Force bool `mapstructure:"force"`
+
 	ctx interpolate.Context
 }
 
@@ -77,65 +96,56 @@ func (p *PostProcessor) Configure(raws ...any) error {
 	return nil
 }
 
-// PostProcess will delete the AMI
+// PostProcess will delete the AMIs of the artifact, or those selected by
+// source_ami_ids and source_ami_filter. Any artifact whose ID lists AMIs as
+// region:ami or account:region:ami is accepted.
+//
+// With `targets` or `copy_manifest`, the copies ami-copy made of the AMIs
+// are deleted as well, before the AMIs themselves. AMIs listed with an
+// account other than that of the credentials are deleted with the
+// credentials of the matching target.
+//
+// AMIs with deregistration protection or still in use are refused unless
+// `force` is set. Every AMI is attempted, the errors are returned together
+// and a summary of the deletions is printed. With `dry_run`, nothing is
+// deleted.
 func (p *PostProcessor) PostProcess(ctx context.Context, ui packer.Ui, artifact packer.Artifact) (packer.Artifact, bool, bool, error) {
-	// Ensure we're being called from a supported builder
-	switch artifact.BuilderId() {
-	case ebs.BuilderId,
-		ebssurrogate.BuilderId,
-		ebsvolume.BuilderId,
-		chroot.BuilderId,
-		instance.BuilderId:
-		break
-	default:
-		return artifact, false, false, fmt.Errorf("unexpected artifact type: %s\nCan only export from Amazon builders", artifact.BuilderId())
+	awsCfg, err := p.config.AccessConfig.GetAWSConfig(ctx)
+	if err != nil {
+		return artifact, false, false, err
 	}
 
-	awsCfg, err := p.config.AccessConfig.GetAWSConfig(ctx)
+	amis, err := helpers.SourceAMIs(ctx, artifact, p.config.SourceAMIIDs, &p.config.SourceAMIFilter, *awsCfg)
 	if err != nil {
 		return artifact, false, false, err
 	}
 
-	amis := amisFromArtifactID(artifact.Id())
-	for _, ami := range amis {
-		var img *types.Image
-		cfg := awsCfg.Copy()
-		cfg.Region = ami.region
-		client := ec2.NewFromConfig(cfg)
-		if img, err = helpers.LocateSingleAMI(ctx, ami.id, client); err != nil || img == nil {
-			return artifact, false, false, err
-		}
-		ui.Sayf("Deregistering %s", *img.ImageId)
-		if _, err = client.DeregisterImage(ctx, &ec2.DeregisterImageInput{
-			ImageId: img.ImageId,
-		}); err != nil {
-			return artifact, false, false, err
+	// Copies are deleted before the AMIs they were made from
+	copies, configs, errs := p.copies(ctx, ui, *awsCfg, amis)
+
+	var deletions []*deletion
+	for _, ami := range append(copies, amis...) {
+		cfg, err := amiConfig(*awsCfg, configs, ami)
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, err)
+			deletions = append(deletions, &deletion{ami: ami, status: statusFailed, reason: err.Error()})
+			continue
 		}
-		for _, bdm := range img.BlockDeviceMappings {
-			if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
-				ui.Sayf("Deleting %s", *bdm.Ebs.SnapshotId)
-				if _, err = client.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{
-					SnapshotId: bdm.Ebs.SnapshotId,
-				}); err != nil {
-					return artifact, false, false, err
-				}
-			}
+		d, err := p.deleteAMI(ctx, ui, ec2.NewFromConfig(cfg), ami)
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, err)
 		}
+		deletions = append(deletions, d)
 	}
 
-	return artifact, true, true, nil
-}
-
-// ami encapsulates simplistic details about an AMI.
-type ami struct {
-	id, region string
-}
+	if p.config.DryRun {
+		ui.Sayf("Dry run, planned deletions:\n%s", formatSummary(deletions))
+	} else {
+		ui.Sayf("Deletions:\n%s", formatSummary(deletions))
+	}
 
-// amisFromArtifactID returns an AMI slice from a Packer artifact id.
-func amisFromArtifactID(artifactID string) (amis []*ami) {
-	for amiStr := range strings.SplitSeq(artifactID, ",") {
-		pair := strings.SplitN(amiStr, ":", 2)
-		amis = append(amis, &ami{region: pair[0], id: pair[1]})
+	if errs != nil && len(errs.Errors) != 0 {
+		return artifact, false, false, errs
 	}
-	return amis
+	return artifact, true, true, nil
 }
@@ -3,6 +3,7 @@
 package ami_delete
 
 import (
+	ami_copy "github.com/bdwyertech/packer-plugin-aws/post-processor/ami-copy"
 	"github.com/hashicorp/hcl/v2/hcldec"
 	"github.com/hashicorp/packer-plugin-amazon/builder/common"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
@@ -64,6 +65,13 @@ type FlatConfig struct {
 	SnapshotUsers                  []string                                    `mapstructure:"snapshot_users" required:"false" cty:"snapshot_users" hcl:"snapshot_users"`
 	SnapshotGroups                 []string                                    `mapstructure:"snapshot_groups" required:"false" cty:"snapshot_groups" hcl:"snapshot_groups"`
 	DeregistrationProtection       *common.FlatDeregistrationProtectionOptions `mapstructure:"deregistration_protection" required:"false" cty:"deregistration_protection" hcl:"deregistration_protection"`
+	SourceAMIIDs                   []string                                    `mapstructure:"source_ami_ids" cty:"source_ami_ids" hcl:"source_ami_ids"`
+	SourceAMIFilter                *common.FlatAmiFilterOptions                `mapstructure:"source_ami_filter" cty:"source_ami_filter" hcl:"source_ami_filter"`
+	Targets                        []ami_copy.FlatTarget                       `mapstructure:"targets" cty:"targets" hcl:"targets"`
+	DestinationRegions             []string                                    `mapstructure:"destination_regions" cty:"destination_regions" hcl:"destination_regions"`
+	CopyManifest                   *string                                     `mapstructure:"copy_manifest" cty:"copy_manifest" hcl:"copy_manifest"`
+	DryRun                         *bool                                       `mapstructure:"dry_run" cty:"dry_run" hcl:"dry_run"`
+	Force                          *bool                                       `mapstructure:"force" cty:"force" hcl:"force"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -130,6 +138,13 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"snapshot_users":                 &hcldec.AttrSpec{Name: "snapshot_users", Type: cty.List(cty.String), Required: false},
 		"snapshot_groups":                &hcldec.AttrSpec{Name: "snapshot_groups", Type: cty.List(cty.String), Required: false},
 		"deregistration_protection":      &hcldec.BlockSpec{TypeName: "deregistration_protection", Nested: hcldec.ObjectSpec((*common.FlatDeregistrationProtectionOptions)(nil).HCL2Spec())},
+		"source_ami_ids":                 &hcldec.AttrSpec{Name: "source_ami_ids", Type: cty.List(cty.String), Required: false},
+		"source_ami_filter":              &hcldec.BlockSpec{TypeName: "source_ami_filter", Nested: hcldec.ObjectSpec((*common.FlatAmiFilterOptions)(nil).HCL2Spec())},
+		"targets":                        &hcldec.BlockListSpec{TypeName: "targets", Nested: hcldec.ObjectSpec((*ami_copy.FlatTarget)(nil).HCL2Spec())},
+		"destination_regions":            &hcldec.AttrSpec{Name: "destination_regions", Type: cty.List(cty.String), Required: false},
+		"copy_manifest":                  &hcldec.AttrSpec{Name: "copy_manifest", Type: cty.String, Required: false},
+		"dry_run":                        &hcldec.AttrSpec{Name: "dry_run", Type: cty.Bool, Required: false},
+		"force":                          &hcldec.AttrSpec{Name: "force", Type: cty.Bool, Required: false},
 	}
 	return s
 }